@@ -0,0 +1,112 @@
+package main
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/DCSO/bloom"
+
+	"github.com/DCSO/gotie/v1"
+)
+
+type BloomParams struct {
+	Input            string `goptions:"-i,--input, description='Read IOCs from a JSON file written by the iocs verb (- for stdin) instead of querying TIE'"`
+	Output           string `goptions:"-o,--output, description='Write the filter to the given file instead of stdout'"`
+	Add              string `goptions:"-a,--add, description='Add the IOCs to an existing filter file, which is updated unless --output is given'"`
+	N                string `goptions:"--bloom-n, description='Capacity (0 to fit the number of IOCs)'"`
+	P                string `goptions:"--bloom-p, description='False positive rate'"`
+	Normalize        bool   `goptions:"--normalize, description='Normalize IOC values before adding them'"`
+	Query            string `goptions:"-q,--query, description='Query string (case insensitive)'"`
+	Period           string `goptions:"-p,--period, description='Use the TIE feed for given period (hourly|daily|weekly|monthly)'"`
+	Category         string `goptions:"-c,--category, description='specify comma-separated IOC categories'"`
+	DataType         string `goptions:"-t,--type, description='TIE IOC data type'"`
+	Severity         string `goptions:"--severity, description='Specify severity (can be a range)'"`
+	Source_pseudonym string `goptions:"--source, description='Specify source pseudonym'"`
+	Confidence       string `goptions:"--confidence, description='Specify confidence (can be a range)'"`
+	Limit            string `goptions:"--limit, description='Specify limit of IOCs to query at once'"`
+	Updated_since    string `goptions:"--updated-since, description='Limit to IOCs updated since the given date'"`
+	Created_since    string `goptions:"--created-since, description='Limit to IOCs created since the given date'"`
+	First_seen_since string `goptions:"--first-seen-since, description='Limit to IOCs first seen since the given date'"`
+	Last_seen_since  string `goptions:"--last-seen-since, description='Limit to IOCs last seen since the given date'"`
+}
+
+// newBloomBuilder configures a bloom builder from the command line options
+func newBloomBuilder(params BloomParams, w io.Writer) (*gotie.BloomWriter, error) {
+	bw := gotie.NewBloomWriter(w)
+	bw.Normalize = params.Normalize
+
+	n, err := strconv.ParseUint(params.N, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bloom capacity: %v", err)
+	}
+	bw.Capacity = n
+	if bw.FalsePositiveRate, err = strconv.ParseFloat(params.P, 64); err != nil {
+		return nil, fmt.Errorf("invalid bloom false positive rate: %v", err)
+	}
+
+	if params.Add != "" {
+		if bw.Base, err = bloom.LoadFilter(params.Add, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return bw, nil
+}
+
+// buildBloom builds a bloom filter from IOCs read from a file or queried
+// from TIE and writes it to the output file or stdout.
+func buildBloom(ctx context.Context, clientOpts []gotie.ClientOption, params BloomParams, debug bool) (err error) {
+	output := params.Output
+	if output == "" {
+		output = params.Add
+	}
+
+	// The filter is written to a file only once it is complete, so an
+	// existing filter is not truncated before it has been loaded
+	var out io.Writer = os.Stdout
+	var f *os.File
+	if output != "" {
+		f, err = ioutil.TempFile(filepath.Dir(output), ".gotie-bloom")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		out = f
+	}
+
+	bw, err := newBloomBuilder(params, out)
+	if err != nil {
+		return err
+	}
+	bw.Logger = debugLogger(debug)
+
+	if err = loadIOCs(ctx, clientOpts, params, bw, debug); err != nil {
+		return err
+	}
+
+	if debug {
+		log.Printf("added %d values to the bloom filter", bw.Len())
+	}
+
+	if f != nil {
+		if err = f.Chmod(0644); err != nil {
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+		return os.Rename(f.Name(), output)
+	}
+
+	return nil
+}
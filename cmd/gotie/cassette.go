@@ -0,0 +1,41 @@
+package main
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/DCSO/gotie/v1/cassette"
+)
+
+// cassetteClient returns an HTTP client recording the session to the file
+// record or replaying the session recorded in the file replay, or nil if
+// neither is given. The returned function closes the cassette.
+func cassetteClient(record, replay string) (*http.Client, func() error, error) {
+	switch {
+	case record != "" && replay != "":
+		return nil, nil, errors.New("--record and --replay can not be combined")
+	case record != "":
+		f, err := os.Create(record)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &http.Client{Transport: cassette.NewRecordingTransport(nil, f)}, f.Close, nil
+	case replay != "":
+		f, err := os.Open(replay)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		interactions, err := cassette.Read(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &http.Client{Transport: cassette.NewReplayTransport(interactions)}, func() error { return nil }, nil
+	default:
+		return nil, func() error { return nil }, nil
+	}
+}
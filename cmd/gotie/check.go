@@ -0,0 +1,119 @@
+package main
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/DCSO/bloom"
+
+	"github.com/DCSO/gotie/v1"
+)
+
+type CheckParams struct {
+	Read             string `goptions:"-r,--read, description='Read observables from the given file instead of stdin'"`
+	Tokens           bool   `goptions:"--tokens, description='Check every token of the input lines instead of whole lines'"`
+	Input            string `goptions:"-i,--input, description='Read IOCs from a JSON file written by the iocs verb (- for stdin) instead of querying TIE'"`
+	Bloom            string `goptions:"-b,--bloom, description='Check against a bloom filter file instead of querying TIE'"`
+	Query            string `goptions:"-q,--query, description='Query string (case insensitive)'"`
+	Period           string `goptions:"-p,--period, description='Use the TIE feed for given period (hourly|daily|weekly|monthly)'"`
+	Category         string `goptions:"-c,--category, description='specify comma-separated IOC categories'"`
+	DataType         string `goptions:"-t,--type, description='TIE IOC data type'"`
+	Severity         string `goptions:"--severity, description='Specify severity (can be a range)'"`
+	Source_pseudonym string `goptions:"--source, description='Specify source pseudonym'"`
+	Confidence       string `goptions:"--confidence, description='Specify confidence (can be a range)'"`
+	Limit            string `goptions:"--limit, description='Specify limit of IOCs to query at once'"`
+	Updated_since    string `goptions:"--updated-since, description='Limit to IOCs updated since the given date'"`
+	Created_since    string `goptions:"--created-since, description='Limit to IOCs created since the given date'"`
+	First_seen_since string `goptions:"--first-seen-since, description='Limit to IOCs first seen since the given date'"`
+	Last_seen_since  string `goptions:"--last-seen-since, description='Limit to IOCs last seen since the given date'"`
+}
+
+// isTokenSeparator reports whether c separates observables in log lines
+func isTokenSeparator(c rune) bool {
+	switch c {
+	case ' ', '\t', '"', '\'', ',', ';', '<', '>', '(', ')', '[', ']', '{', '}', '|':
+		return true
+	}
+	return false
+}
+
+// formatMatch formats a match of observable for the check output
+func formatMatch(observable string, m gotie.Match) string {
+	if m.IOC == nil {
+		return fmt.Sprintf("%s\t%s\tbloom", observable, m.Observable)
+	}
+	return fmt.Sprintf("%s\t%s\t%s\tseverity=%d\tconfidence=%d\tcategories=%s",
+		observable, m.IOC.Value, m.IOC.DataType, m.IOC.MaxSeverity, m.IOC.MaxConfidence,
+		strings.Join(m.IOC.Categories, ","))
+}
+
+// checkObservables writes the matches of the observables read from r to w
+func checkObservables(m *gotie.Matcher, r io.Reader, w io.Writer, tokens bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		observables := []string{scanner.Text()}
+		if tokens {
+			observables = strings.FieldsFunc(scanner.Text(), isTokenSeparator)
+		}
+
+		for _, observable := range observables {
+			observable = strings.TrimSpace(observable)
+			for _, match := range m.Match(observable) {
+				if _, err := fmt.Fprintln(w, formatMatch(observable, match)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// check matches observables against IOCs from a JSON file, a bloom filter
+// or TIE and prints the matches to stdout.
+func check(ctx context.Context, clientOpts []gotie.ClientOption, params CheckParams, debug bool) error {
+	if params.Input == "-" && params.Read == "" {
+		return errors.New("IOCs and observables can not both be read from stdin, use -r for the observables")
+	}
+
+	m := gotie.NewMatcher()
+
+	if params.Bloom != "" {
+		f, err := bloom.LoadFilter(params.Bloom, false)
+		if err != nil {
+			return err
+		}
+		m.Filter = f
+	}
+	if params.Bloom == "" || params.Input != "" {
+		if err := loadIOCs(ctx, clientOpts, params, m, debug); err != nil {
+			return err
+		}
+		if debug {
+			log.Printf("loaded %d IOCs", m.Len())
+		}
+	}
+
+	in := io.Reader(os.Stdin)
+	if params.Read != "" {
+		f, err := os.Open(params.Read)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	return checkObservables(m, in, os.Stdout, params.Tokens)
+}
@@ -14,8 +14,15 @@ import (
 var CONF = config{}
 
 type config struct {
-	TieToken      string `toml:"tie_token"`
-	PingBackToken string `toml:"pingback_token"`
+	TieToken      string  `toml:"tie_token"`
+	PingBackToken string  `toml:"pingback_token"`
+	Retries       int     `toml:"retries"`
+	RetryWait     string  `toml:"retry_wait"`
+	RetryMaxWait  string  `toml:"retry_max_wait"`
+	PageDelay     string  `toml:"page_delay"`
+	ParallelPages int     `toml:"parallel_pages"`
+	RateLimit     float64 `toml:"rate_limit"`
+	RateBurst     int     `toml:"rate_burst"`
 }
 
 func getDefaultConfPath() string {
@@ -27,6 +34,12 @@ func getDefaultConfPath() string {
 	return filepath.Join(dir, ".gotie")
 }
 
+// getDefaultStorePath returns the path of the local IOC store used by
+// --offline and sync --log-store
+func getDefaultStorePath() string {
+	return getDefaultConfPath() + "-store"
+}
+
 func loadConfig(path string) error {
 	_, err := toml.DecodeFile(path, &CONF)
 	if err != nil {
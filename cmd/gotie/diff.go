@@ -0,0 +1,61 @@
+package main
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/DCSO/gotie/v1"
+)
+
+type DiffParams struct {
+	Old    string `goptions:"-o,--old, description='JSON file with the old IOCs, as written by the iocs verb', obligatory"`
+	New    string `goptions:"-n,--new, description='JSON file with the new IOCs', obligatory"`
+	Format string `goptions:"-f,--format, description='Specify output format (text|json|csv)'"`
+}
+
+// readIOCs reads the IOCs of a JSON file
+func readIOCs(path string) ([]gotie.IOC, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ch, err := gotie.GetIOCJSONInChan(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	data, err := gotie.IOCChanCollect(ch)
+	if err != nil {
+		return nil, err
+	}
+	return data.Iocs, nil
+}
+
+// diff writes the differences between two IOC snapshots to w
+func diff(params DiffParams, w io.Writer) error {
+	old, err := readIOCs(params.Old)
+	if err != nil {
+		return err
+	}
+	new, err := readIOCs(params.New)
+	if err != nil {
+		return err
+	}
+
+	d := gotie.DiffIOCs(old, new)
+	switch params.Format {
+	case "text":
+		return d.WriteText(w)
+	case "json":
+		return d.WriteJSON(w)
+	case "csv":
+		return d.WriteCSV(w)
+	default:
+		return fmt.Errorf("unsupported diff format %q", params.Format)
+	}
+}
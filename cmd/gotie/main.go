@@ -4,11 +4,15 @@ package main
 // Copyright (c) 2016-2018, DCSO GmbH
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
-	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -20,9 +24,15 @@ import (
 
 type IOCSParams struct {
 	Query            string `goptions:"-q,--query, description='Query string (case insensitive)'"`
-	Format           string `goptions:"-f,--format, description='Specify output format (bloom|csv|json|stix)'"`
+	Format           string `goptions:"-f,--format, description='Specify output format (bloom|csv|json|misp|ndjson|rpz|snort|stix|stix2|suricata|zeek)'"`
 	N                string `goptions:"--bloom-n, description='Bloom output: capacity'"`
 	P                string `goptions:"--bloom-p, description='Bloom output: false positive rate'"`
+	Sid_base         string `goptions:"--sid-base, description='Rule output: SID of the first rule'"`
+	Rule_lists       string `goptions:"--rule-lists, description='Rule output: write IP reputation and hash lists to the given directory'"`
+	Rpz_action       string `goptions:"--rpz-action, description='RPZ output: policy action (nxdomain|nodata|drop|passthru) or record'"`
+	Rpz_wildcard     bool   `goptions:"--rpz-wildcard, description='RPZ output: also match all subdomains'"`
+	Offline          bool   `goptions:"--offline, description='Query the local store instead of TIE'"`
+	Store            string `goptions:"--store, description='Local store file used by --offline'"`
 	Category         string `goptions:"-c,--category, description='specify comma-separated IOC categories'"`
 	DataType         string `goptions:"-t,--type, description='TIE IOC data type to search exclusively'"`
 	Severity         string `goptions:"--severity, description='Specify severity (can be a range)'"`
@@ -41,9 +51,15 @@ type IOCSParams struct {
 
 type FeedParams struct {
 	Period           string `goptions:"-p,--period, description='Get TIE feed for given period (hourly|daily|weekly|monthly)', obligatory"`
-	Format           string `goptions:"-f,--format, description='Specify output format (csv|json|stix)'"`
+	Format           string `goptions:"-f,--format, description='Specify output format (bloom|csv|json|misp|ndjson|rpz|snort|stix|stix2|suricata|zeek)'"`
 	N                string `goptions:"--bloom-n, description='Bloom output: capacity'"`
 	P                string `goptions:"--bloom-p, description='Bloom output: false positive rate'"`
+	Sid_base         string `goptions:"--sid-base, description='Rule output: SID of the first rule'"`
+	Rule_lists       string `goptions:"--rule-lists, description='Rule output: write IP reputation and hash lists to the given directory'"`
+	Rpz_action       string `goptions:"--rpz-action, description='RPZ output: policy action (nxdomain|nodata|drop|passthru) or record'"`
+	Rpz_wildcard     bool   `goptions:"--rpz-wildcard, description='RPZ output: also match all subdomains'"`
+	Offline          bool   `goptions:"--offline, description='Query the local store instead of TIE'"`
+	Store            string `goptions:"--store, description='Local store file used by --offline'"`
 	Category         string `goptions:"-c,--category, description='specify comma-separated IOC categories'"`
 	DataType         string `goptions:"-t,--type, description='Specify a valid TIE IOC data type', obligatory"`
 	Severity         string `goptions:"--severity, description='Specify severity (can be a range)'"`
@@ -59,11 +75,6 @@ type FeedParams struct {
 	Last_seen_until  string `goptions:"--last-seen-until, description='Limit to IOCs last seen until the given date'"`
 }
 
-type PingBackParams struct {
-	DataType string `goptions:"-t,--type, description='Specify a valid TIE IOC data type', obligatory"`
-	Value    string `goptions:"-v,--value, description='Specify a valid TIE IOC data value', obligatory"`
-}
-
 type Params interface{}
 
 func parseTime(timeString string) (time.Time, error) {
@@ -95,73 +106,423 @@ func parseTime(timeString string) (time.Time, error) {
 	return mtime, err
 }
 
-func buildArgs(params Params, typestr string, debug bool) string {
-	sharedParams := map[string]bool{
-
-		"Severity":         true,
-		"Confidence":       true,
-		"Category":         true,
-		"Source_pseudonym": true,
-		"Updated_since":    true,
-		"Updated_until":    true,
-		"Created_since":    true,
-		"Created_until":    true,
-		"First_seen_since": true,
-		"First_seen_until": true,
-		"Last_seen_since":  true,
-		"Last_seen_until":  true,
-		"N":                true,
-		"P":                true,
-	}
-	var p reflect.Value
-
-	if typestr == "iocs" {
-		iocparams := params.(IOCSParams)
-		p = reflect.ValueOf(&iocparams).Elem()
-	} else if typestr == "feed" {
-		feedparams := params.(FeedParams)
-		p = reflect.ValueOf(&feedparams).Elem()
-	}
-	values := []string{""}
-	for i := 0; i < p.NumField(); i++ {
-		field_name := p.Type().Field(i).Name
-		field_value := p.Field(i).Interface().(string)
-		if sharedParams[field_name] && field_value != "" {
-			var err error
-			outval := field_value
-			matched, _ := regexp.MatchString("_(since|until)$", field_name)
-			if matched {
-				var timeval time.Time
-				timeval, err = parseTime(field_value)
-				if err != nil {
-					log.Fatal(err)
-				}
-				outval = timeval.Format("2006-01-02T15:04:05Z")
+// param returns the value of the named option in params, or an empty string
+// if the verb does not have the option
+func param(params Params, name string) string {
+	if f := reflect.ValueOf(params).FieldByName(name); f.IsValid() {
+		return fmt.Sprint(f.Interface())
+	}
+	return ""
+}
+
+// buildQuery builds the IOC query from the filter options shared by the
+// iocs and feed verbs.
+func buildQuery(params Params, debug bool) (*gotie.IOCQuery, error) {
+	field := func(name string) string {
+		return param(params, name)
+	}
+	list := func(name string) []string {
+		var items []string
+		for _, item := range strings.Split(field(name), ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
 			}
-			argPair := url.QueryEscape(strings.ToLower(field_name)) + "=" + url.QueryEscape(outval)
-			values = append(values, argPair)
-		} else {
+		}
+		return items
+	}
+
+	q := gotie.NewIOCQuery()
+
+	if s := field("Severity"); s != "" {
+		r, err := gotie.ParseRange(s)
+		if err != nil {
+			return nil, err
+		}
+		q.Severity(r)
+	}
+	if s := field("Confidence"); s != "" {
+		r, err := gotie.ParseRange(s)
+		if err != nil {
+			return nil, err
+		}
+		q.Confidence(r)
+	}
+	q.Categories(list("Category")...)
+	q.SourcePseudonyms(list("Source_pseudonym")...)
+
+	dates := []struct {
+		name string
+		set  func(time.Time) *gotie.IOCQuery
+	}{
+		{"Updated_since", q.UpdatedSince},
+		{"Updated_until", q.UpdatedUntil},
+		{"Created_since", q.CreatedSince},
+		{"Created_until", q.CreatedUntil},
+		{"First_seen_since", q.FirstSeenSince},
+		{"First_seen_until", q.FirstSeenUntil},
+		{"Last_seen_since", q.LastSeenSince},
+		{"Last_seen_until", q.LastSeenUntil},
+	}
+	for _, date := range dates {
+		s := field(date.name)
+		if s == "" {
 			if debug {
-				log.Printf("unknown or empty parameter %s skipped\n", field_name)
+				log.Printf("empty parameter %s skipped\n", date.name)
 			}
+			continue
+		}
+		t, err := parseTime(s)
+		if err != nil {
+			return nil, err
+		}
+		date.set(t)
+	}
+
+	if format := field("Format"); format == "bloom" || format == "bloomv1" {
+		n, err := strconv.ParseUint(field("N"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bloom capacity: %v", err)
 		}
+		prob, err := strconv.ParseFloat(field("P"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bloom false positive rate: %v", err)
+		}
+		q.Bloom(n, prob)
 	}
-	return strings.Join(values, "&")
+
+	return q, q.Err()
 }
 
 type Options struct {
 	ConfPath    string        `goptions:"-c,--conf,description='Set non default config path'"`
 	TieAPI      string        `goptions:"--tie-api,description='TIE API endpoint'"`
 	PingbackAPI string        `goptions:"--tie-pingback-api,description='TIE Pingback API endpoint'"`
+	Retries     int           `goptions:"--retries,description='Maximum attempts of failing requests'"`
+	RetryWait   string        `goptions:"--retry-wait,description='Wait after the first failed attempt, e.g. 5s'"`
+	RetryMax    string        `goptions:"--retry-max-wait,description='Maximum wait between attempts'"`
+	PageDelay   string        `goptions:"--page-delay,description='Minimum time between page requests, e.g. 100ms'"`
+	Parallel    int           `goptions:"--parallel-pages,description='Number of result pages fetched concurrently'"`
+	RateLimit   float64       `goptions:"--rate-limit,description='Maximum requests per second to TIE'"`
+	RateBurst   int           `goptions:"--rate-burst,description='Requests allowed at once within the rate limit'"`
+	Record      string        `goptions:"--record,description='Record the HTTP exchanges with TIE to a cassette file'"`
+	Replay      string        `goptions:"--replay,description='Replay the HTTP exchanges of a recorded cassette file instead of contacting TIE'"`
 	Debug       bool          `goptions:"-d,--debug,description='Print debug messages'"`
 	Help        goptions.Help `goptions:"-h, --help, description='Show this help'"`
 
 	goptions.Verbs
 	IOCS     IOCSParams     `goptions:"iocs"`
 	Feed     FeedParams     `goptions:"feed"`
+	Bloom    BloomParams    `goptions:"bloom"`
+	Check    CheckParams    `goptions:"check"`
+	Sync     SyncParams     `goptions:"sync"`
+	Diff     DiffParams     `goptions:"diff"`
 	PingBack PingBackParams `goptions:"pingback"`
 }
 
+// ruleFiles are the list files written next to the rules with --rule-lists
+var ruleFiles = struct{ IPRep, MD5, SHA256 string }{
+	IPRep:  "tie-iprep.list",
+	MD5:    "tie-md5.list",
+	SHA256: "tie-sha256.list",
+}
+
+// debugLogger returns the logger for debug messages of the writers, nil
+// unless debug is set
+func debugLogger(debug bool) *log.Logger {
+	if !debug {
+		return nil
+	}
+	return log.New(os.Stderr, "", log.LstdFlags)
+}
+
+// newWriter returns the IOCWriter generating output format t, configured
+// from the options in params and logging to logger. For formats generated
+// by TIE it returns nil unless local is set. The returned function closes
+// the files opened for the writer.
+func newWriter(t gotie.MimeType, params Params, w io.Writer, local bool, logger *log.Logger) (iw gotie.IOCWriter, closeFiles func() error, err error) {
+	defer func() {
+		if iw != nil {
+			gotie.SetWriterLogger(iw, logger)
+		}
+	}()
+	noFiles := func() error { return nil }
+
+	switch t {
+	case gotie.SURICATA, gotie.SNORT:
+		return newRuleWriter(t, param(params, "Sid_base"), param(params, "Rule_lists"), w)
+	case gotie.RPZ:
+		zw := gotie.NewRPZWriter(w)
+		zw.Action = gotie.ParseRPZAction(param(params, "Rpz_action"))
+		zw.Wildcard = param(params, "Rpz_wildcard") == "true"
+		return zw, noFiles, nil
+	case gotie.BLOOMv2:
+		if !local {
+			return nil, noFiles, nil
+		}
+		bw := gotie.NewBloomWriter(w)
+		n, err := strconv.ParseUint(param(params, "N"), 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid bloom capacity: %v", err)
+		}
+		bw.Capacity = n
+		if bw.FalsePositiveRate, err = strconv.ParseFloat(param(params, "P"), 64); err != nil {
+			return nil, nil, fmt.Errorf("invalid bloom false positive rate: %v", err)
+		}
+		return bw, noFiles, nil
+	}
+
+	if !local {
+		return nil, noFiles, nil
+	}
+	iw, err = gotie.NewIOCWriter(t, w)
+	return iw, noFiles, err
+}
+
+// doRequest writes the result of r to stdout, configuring the locally
+// generated output formats from the options in params.
+func doRequest(ctx context.Context, client *gotie.Client, r gotie.Request, t gotie.MimeType, params Params, debug bool) (err error) {
+	iw, closeFiles, err := newWriter(t, params, os.Stdout, false, debugLogger(debug))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := closeFiles(); err == nil {
+			err = cerr
+		}
+	}()
+
+	if iw == nil {
+		return client.DoContext(ctx, r, t, os.Stdout)
+	}
+	agg := gotie.NewIOCWriterAggregator(os.Stdout, func(w io.Writer) gotie.IOCWriter {
+		return iw
+	})
+	return client.AggregateContext(ctx, r, t, agg, os.Stdout)
+}
+
+// loadIOCs passes IOCs to iw and closes it. The IOCs are read from the JSON
+// file given by the Input option if set, otherwise the IOCs selected by the
+// query options in params are queried, or the feed if a period is given.
+func loadIOCs(ctx context.Context, clientOpts []gotie.ClientOption, params Params, iw gotie.IOCWriter, debug bool) error {
+	if input := param(params, "Input"); input != "" {
+		in := io.Reader(os.Stdin)
+		if input != "-" {
+			f, err := os.Open(input)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		iocs, err := gotie.GetIOCJSONInChan(in)
+		if err != nil {
+			return err
+		}
+		for result := range iocs {
+			if err := iw.WriteIOC(result.IOC); err != nil {
+				return err
+			}
+		}
+		return iw.Close()
+	}
+
+	limit, err := strconv.ParseInt(param(params, "Limit"), 10, 32)
+	if err != nil {
+		return err
+	}
+	client := gotie.NewClient(append(clientOpts, gotie.WithIOCLimit(int(limit)))...)
+
+	args, err := buildQuery(params, debug)
+	if err != nil {
+		return err
+	}
+	var request gotie.Request = &gotie.IOCRequest{
+		Query:    param(params, "Query"),
+		DataType: param(params, "DataType"),
+		Args:     args,
+		MimeType: gotie.JSON,
+	}
+	if period := param(params, "Period"); period != "" {
+		request = &gotie.FeedRequest{
+			FeedPeriod: period,
+			DataType:   param(params, "DataType"),
+			Args:       args,
+			MimeType:   gotie.JSON,
+		}
+	}
+
+	agg := gotie.NewIOCWriterAggregator(ioutil.Discard, func(w io.Writer) gotie.IOCWriter {
+		return iw
+	})
+	return client.AggregateContext(ctx, request, gotie.JSON, agg, ioutil.Discard)
+}
+
+// newRuleWriter returns a writer for Suricata or Snort rules, writing IP
+// and hash lists to listDir if given. The returned function closes the list
+// files.
+func newRuleWriter(t gotie.MimeType, sidBase, listDir string, w io.Writer) (gotie.IOCWriter, func() error, error) {
+	var lists []*os.File
+	closeLists := func() (err error) {
+		for _, f := range lists {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return
+	}
+
+	sid, err := strconv.Atoi(sidBase)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid SID base %q: %v", sidBase, err)
+	}
+
+	rw := gotie.NewRuleWriter(w)
+	rw.SIDBase = sid
+	if t == gotie.SNORT {
+		rw.Dialect = gotie.RuleDialectSnort
+	}
+	if listDir != "" {
+		for _, list := range []struct {
+			name string
+			w    *io.Writer
+		}{
+			{ruleFiles.IPRep, &rw.IPRep},
+			{ruleFiles.MD5, &rw.MD5List},
+			{ruleFiles.SHA256, &rw.SHA256List},
+		} {
+			f, err := os.Create(filepath.Join(listDir, list.name))
+			if err != nil {
+				closeLists()
+				return nil, nil, err
+			}
+			lists = append(lists, f)
+			*list.w = f
+		}
+		rw.MD5ListName = ruleFiles.MD5
+		rw.SHA256ListName = ruleFiles.SHA256
+	}
+
+	return rw, closeLists, nil
+}
+
+// durationSetting parses the duration given by a command line flag or else
+// by a config file entry. It returns false if neither is set.
+func durationSetting(name, flag, entry string) (time.Duration, bool, error) {
+	s := flag
+	if s == "" {
+		s = entry
+	}
+	if s == "" {
+		return 0, false, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, false, fmt.Errorf("invalid %s %q", name, s)
+	}
+	return d, true, nil
+}
+
+// retryPolicy returns the retry policy set on the command line or else in
+// the config file
+func retryPolicy(options Options, conf config) (*gotie.BackoffPolicy, error) {
+	policy := gotie.DefaultRetryPolicy()
+
+	if options.Retries > 0 {
+		policy.MaxAttempts = options.Retries
+	} else if conf.Retries > 0 {
+		policy.MaxAttempts = conf.Retries
+	}
+
+	for _, d := range []struct {
+		name        string
+		flag, entry string
+		value       *time.Duration
+	}{
+		{"retry wait", options.RetryWait, conf.RetryWait, &policy.Wait},
+		{"maximum retry wait", options.RetryMax, conf.RetryMaxWait, &policy.MaxWait},
+	} {
+		v, ok, err := durationSetting(d.name, d.flag, d.entry)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			*d.value = v
+		}
+	}
+
+	return policy, nil
+}
+
+// retryOptions returns the client options for the retry policy and the
+// page delay, taken from the command line or else from the config file
+func retryOptions(options Options, conf config) ([]gotie.ClientOption, error) {
+	policy, err := retryPolicy(options, conf)
+	if err != nil {
+		return nil, err
+	}
+	opts := []gotie.ClientOption{gotie.WithRetryPolicy(policy)}
+
+	delay, ok, err := durationSetting("page delay", options.PageDelay, conf.PageDelay)
+	if err != nil {
+		return nil, err
+	} else if ok {
+		opts = append(opts, gotie.WithPageDelay(delay))
+	}
+
+	return opts, nil
+}
+
+// rateLimitOption returns the client option for the rate limit, taken from
+// the command line or else from the config file, or nil if there is none
+func rateLimitOption(options Options, conf config) gotie.ClientOption {
+	rps, burst := options.RateLimit, options.RateBurst
+	if rps <= 0 {
+		rps = conf.RateLimit
+	}
+	if burst <= 0 {
+		burst = conf.RateBurst
+	}
+	if rps <= 0 {
+		return nil
+	}
+	return gotie.WithRateLimit(rps, burst)
+}
+
+// Exit codes of failed TIE requests
+const (
+	exitFailure      = 1
+	exitUnauthorized = 3
+	exitInvalid      = 4
+	exitNotFound     = 5
+	exitRateLimited  = 6
+	exitServerError  = 7
+)
+
+// exitCode returns the exit code for err, distinguishing the kinds of
+// errors returned by TIE
+func exitCode(err error) int {
+	switch {
+	case gotie.IsUnauthorized(err):
+		return exitUnauthorized
+	case gotie.IsInvalidRequest(err):
+		return exitInvalid
+	case gotie.IsNotFound(err):
+		return exitNotFound
+	case gotie.IsRateLimited(err):
+		return exitRateLimited
+	case gotie.IsServerError(err):
+		return exitServerError
+	default:
+		return exitFailure
+	}
+}
+
+// fatal logs err and exits with its exit code
+func fatal(err error) {
+	log.Print(err)
+	os.Exit(exitCode(err))
+}
+
 func main() {
 	var err error
 	options := Options{
@@ -174,12 +535,32 @@ func main() {
 			P:                "0.001",
 			First_seen_since: "2015-01-01",
 			Limit:            "1000",
+			Sid_base:         "1000000",
+			Store:            getDefaultStorePath(),
 		},
 		Feed: FeedParams{
-			Format: "csv",
-			N:      "100000",
-			P:      "0.001",
-			Limit:  "1000",
+			Format:   "csv",
+			N:        "100000",
+			P:        "0.001",
+			Limit:    "1000",
+			Sid_base: "1000000",
+			Store:    getDefaultStorePath(),
+		},
+		Bloom: BloomParams{
+			N:     "0",
+			P:     "0.001",
+			Limit: "1000",
+		},
+		Check: CheckParams{
+			Limit: "1000",
+		},
+		Diff: DiffParams{
+			Format: "text",
+		},
+		Sync: SyncParams{
+			State: "gotie-sync.state",
+			Store: "gotie-iocs.json",
+			Limit: "1000",
 		},
 	}
 	goptions.ParseAndFail(&options)
@@ -192,7 +573,6 @@ func main() {
 
 	if options.Debug {
 		log.Println("DEBUG mode activated")
-		gotie.Debug = true
 	}
 
 	// Load the config file and fill the CONF stuct
@@ -200,56 +580,145 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	gotie.AuthToken = CONF.TieToken
 
-	gotie.APIURL = options.TieAPI
-	gotie.PingbackURL = options.PingbackAPI
+	// Abort running requests on interrupt
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	clientOpts := []gotie.ClientOption{
+		gotie.WithAPIURL(options.TieAPI),
+		gotie.WithPingbackURL(options.PingbackAPI),
+		gotie.WithAuthToken(CONF.TieToken),
+		gotie.WithPingbackToken(CONF.PingBackToken),
+		gotie.WithDebug(options.Debug),
+		gotie.WithStreaming(true),
+	}
+	retryOpts, err := retryOptions(options, CONF)
+	if err != nil {
+		fatal(err)
+	}
+	clientOpts = append(clientOpts, retryOpts...)
+	if options.Parallel > 0 {
+		clientOpts = append(clientOpts, gotie.WithParallelPages(options.Parallel))
+	} else if CONF.ParallelPages > 0 {
+		clientOpts = append(clientOpts, gotie.WithParallelPages(CONF.ParallelPages))
+	}
+	if opt := rateLimitOption(options, CONF); opt != nil {
+		clientOpts = append(clientOpts, opt)
+	}
+
+	httpClient, closeCassette, err := cassetteClient(options.Record, options.Replay)
+	if err != nil {
+		fatal(err)
+	}
+	defer closeCassette()
+	if httpClient != nil {
+		clientOpts = append(clientOpts, gotie.WithHTTPClient(httpClient))
+	}
 
 	if options.Verbs == "iocs" {
 		var s int64
 		s, err = strconv.ParseInt(options.IOCS.Limit, 10, 32)
-		if err == nil {
-			gotie.IOCLimit = int(s)
-		} else {
-			log.Fatal(err)
+		if err != nil {
+			fatal(err)
+		}
+		client := gotie.NewClient(append(clientOpts, gotie.WithIOCLimit(int(s)))...)
+
+		request := &gotie.IOCRequest{
+			Query:    options.IOCS.Query,
+			DataType: options.IOCS.DataType,
+		}
+		if request.Args, err = buildQuery(options.IOCS, options.Debug); err != nil {
+			fatal(err)
 		}
-		if gotie.Debug {
-			log.Println(buildArgs(options.IOCS, "iocs", options.Debug))
+		if request.MimeType, err = gotie.NewMimeType(options.IOCS.Format); err != nil {
+			fatal(err)
 		}
 
-		err = gotie.PrintIOCs(options.IOCS.Query, options.IOCS.DataType,
-			buildArgs(options.IOCS, "iocs", options.Debug), options.IOCS.Format)
+		if options.IOCS.Offline {
+			err = queryStore(options.IOCS, request.MimeType, options.Debug)
+		} else {
+			err = doRequest(ctx, client, request, request.MimeType, options.IOCS, options.Debug)
+		}
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	}
 
 	if options.Verbs == "feed" {
 		var s int64
 		s, err = strconv.ParseInt(options.Feed.Limit, 10, 32)
-		if err == nil {
-			gotie.IOCLimit = int(s)
+		if err != nil {
+			fatal(err)
+		}
+		client := gotie.NewClient(append(clientOpts, gotie.WithIOCLimit(int(s)))...)
+
+		request := &gotie.FeedRequest{
+			FeedPeriod: options.Feed.Period,
+			DataType:   options.Feed.DataType,
+		}
+		if request.Args, err = buildQuery(options.Feed, options.Debug); err != nil {
+			fatal(err)
+		}
+		if request.MimeType, err = gotie.NewMimeType(options.Feed.Format); err != nil {
+			fatal(err)
+		}
+
+		if options.Feed.Offline {
+			err = queryStore(options.Feed, request.MimeType, options.Debug)
 		} else {
-			log.Fatal(err)
+			err = doRequest(ctx, client, request, request.MimeType, options.Feed, options.Debug)
 		}
-		err = gotie.PrintPeriodFeeds(options.Feed.Period,
-			strings.ToLower(options.Feed.DataType),
-			buildArgs(options.IOCS, "iocs", options.Debug), options.Feed.Format)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
+		}
+	}
+
+	if options.Verbs == "bloom" {
+		err = buildBloom(ctx, clientOpts, options.Bloom, options.Debug)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	if options.Verbs == "check" {
+		err = check(ctx, clientOpts, options.Check, options.Debug)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	if options.Verbs == "sync" {
+		err = runSync(ctx, clientOpts, options.Sync, options.Debug)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	if options.Verbs == "diff" {
+		err = diff(options.Diff, os.Stdout)
+		if err != nil {
+			fatal(err)
 		}
 	}
 
 	if options.Verbs == "pingback" {
-		if options.PingBack.DataType != "" && options.PingBack.Value != "" {
-			if CONF.PingBackToken == "" {
-				log.Fatal("Please set a valid pingback_token in your config file!")
-			}
-			err = gotie.PingBackCall(options.PingBack.DataType, options.PingBack.Value,
-				CONF.PingBackToken)
-			if err != nil {
-				log.Fatal(err)
-			}
+		if CONF.PingBackToken == "" {
+			log.Fatal("Please set a valid pingback_token in your config file!")
+		}
+		client := gotie.NewClient(clientOpts...)
+		ok, err := pingback(ctx, client, options.PingBack, os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+		if !ok {
+			os.Exit(exitFailure)
 		}
 	}
 
@@ -4,12 +4,255 @@ package main
 // Copyright (c) 2016-2018, DCSO GmbH
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/DCSO/gotie/v1"
 )
 
 func TestMain(m *testing.M) {
 	flag.Parse()
 	os.Exit(m.Run())
 }
+
+func TestBuildQuery(t *testing.T) {
+	params := FeedParams{
+		Period:        "daily",
+		Format:        "bloom",
+		N:             "1000",
+		P:             "0.01",
+		Category:      "c2server, phishing",
+		Severity:      "2-",
+		Updated_since: "2018-01-02",
+	}
+
+	q, err := buildQuery(params, false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	values, err := q.Values()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for k, v := range map[string]string{
+		"category":      "c2server,phishing",
+		"severity":      "2-",
+		"updated_since": "2018-01-02T00:00:00Z",
+		"n":             "1000",
+		"p":             "0.01",
+	} {
+		if got := values.Get(k); got != v {
+			t.Errorf("%v: expected %q, got %q", k, v, got)
+		}
+	}
+
+	params.Severity = "9"
+	if _, err := buildQuery(params, false); err == nil {
+		t.Errorf("expected invalid severity to fail")
+	}
+}
+
+func TestCheckObservables(t *testing.T) {
+	m := gotie.NewMatcher()
+	m.Add(&gotie.IOC{
+		DataType:      "DomainName",
+		Value:         "evil.example.com",
+		MaxSeverity:   4,
+		MaxConfidence: 80,
+		Categories:    []string{"c2server"},
+	})
+
+	in := strings.NewReader("GET http://x/ Host: \"www.evil.example.com\"\ngood.example.com\n")
+	var out bytes.Buffer
+	if err := checkObservables(m, in, &out, true); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expected := "www.evil.example.com\tevil.example.com\tDomainName\tseverity=4\tconfidence=80\tcategories=c2server\n"
+	if out.String() != expected {
+		t.Errorf("unexpected output %q", out.String())
+	}
+
+	if err := check(context.Background(), nil, CheckParams{Input: "-"}, false); err == nil {
+		t.Errorf("expected error for IOCs and observables from stdin")
+	}
+}
+
+func TestStoreQuery(t *testing.T) {
+	params := IOCSParams{
+		Query:         "example",
+		DataType:      "domainname",
+		Category:      "c2server, phishing",
+		Severity:      "3-",
+		Updated_since: "2018-01-02",
+	}
+
+	q, err := storeQuery(params)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if q.Value != "example" || q.DataType != "domainname" || len(q.Categories) != 2 ||
+		q.Severity == nil || q.Severity.Min != 3 || q.Severity.Max != -1 ||
+		q.Updated.Since.Format("2006-01-02") != "2018-01-02" {
+		t.Errorf("unexpected store query %+v", q)
+	}
+
+	if err := queryStore(IOCSParams{Store: "/nonexistent/gotie.store"}, gotie.JSON, false); !os.IsNotExist(err) {
+		t.Errorf("expected missing store to fail, got %v", err)
+	}
+
+	if _, err := storeQuery(FeedParams{Period: "yearly"}); err == nil {
+		t.Errorf("expected error for invalid period")
+	}
+}
+
+func TestSubmitPingback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("value") == "bad" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"message":"invalid value","errors":{"value":["is invalid"]}}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"message":"sighting stored"}`))
+	}))
+	defer srv.Close()
+	client := gotie.NewClient(gotie.WithPingbackURL(srv.URL))
+
+	var buf bytes.Buffer
+	ok, err := submitPingback(context.Background(), client, PingBackParams{DataType: "DomainName", Value: "example.com"}, &buf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !ok || buf.String() != "submitted DomainName example.com (201): sighting stored\n" {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+
+	buf.Reset()
+	ok, err = submitPingback(context.Background(), client, PingBackParams{DataType: "DomainName", Value: "bad", JSON: true}, &buf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := `{"data_type":"DomainName","value":"bad","ok":false,"status_code":422,"message":"invalid value","errors":{"value":["is invalid"]}}` + "\n"
+	if ok || buf.String() != expected {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
+
+func TestSubmitSightings(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("value") == "bad.example.com" {
+			http.Error(w, "invalid value", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		seen[r.FormValue("data_type")+" "+r.FormValue("value")] = r.FormValue("seen")
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	client := gotie.NewClient(gotie.WithPingbackURL(srv.URL))
+
+	in := strings.NewReader(`data_type,value,seen
+domainname,example.com,2018-03-01T10:00:00Z
+# comment
+
+{"data_type":"IPv4","value":"192.0.2.1","seen":"2018-03-01T11:00:00Z"}
+Foo,example.com
+DomainName,bad.example.com
+DomainName,
+`)
+	var buf bytes.Buffer
+	ok, err := submitSightings(context.Background(), client, in, &buf, false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if ok {
+		t.Fatalf("failed entries were not reported")
+	}
+
+	if seen["DomainName example.com"] != "2018-03-01T10:00:00Z" || seen["IPv4 192.0.2.1"] != "2018-03-01T11:00:00Z" {
+		t.Fatalf("unexpected submissions %v", seen)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	sort.Strings(lines)
+	expected := []string{
+		`failed line 6: unknown data type "Foo"`,
+		`failed line 8: empty value`,
+		`rejected DomainName bad.example.com (400): invalid value`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for err, code := range map[error]int{
+		errors.New("failure"):            exitFailure,
+		&gotie.APIError{StatusCode: 403}: exitUnauthorized,
+		&gotie.APIError{StatusCode: 400}: exitInvalid,
+		&gotie.APIError{StatusCode: 404}: exitNotFound,
+		&gotie.APIError{StatusCode: 429}: exitRateLimited,
+		&gotie.APIError{StatusCode: 503}: exitServerError,
+		&gotie.APIError{StatusCode: 409}: exitFailure,
+	} {
+		if c := exitCode(err); c != code {
+			t.Errorf("expected exit code %d for %v, got %d", code, err, c)
+		}
+	}
+}
+
+func TestRetryOptions(t *testing.T) {
+	options := Options{Retries: 5, RetryWait: "2s"}
+	conf := config{Retries: 2, RetryWait: "1s", RetryMaxWait: "1m", PageDelay: "10ms"}
+	policy, err := retryPolicy(options, conf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if policy.MaxAttempts != 5 || policy.Wait != 2*time.Second || policy.MaxWait != time.Minute {
+		t.Fatalf("unexpected retry policy %+v", policy)
+	}
+	opts, err := retryOptions(options, conf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(opts) != 2 {
+		t.Fatalf("expected retry policy and page delay options, got %d", len(opts))
+	}
+
+	if _, err := retryOptions(Options{RetryWait: "soon"}, config{}); err == nil {
+		t.Fatalf("invalid retry wait was accepted")
+	}
+	if _, err := retryOptions(Options{}, config{PageDelay: "-1s"}); err == nil {
+		t.Fatalf("negative page delay was accepted")
+	}
+}
+
+func TestRateLimitOption(t *testing.T) {
+	if opt := rateLimitOption(Options{}, config{}); opt != nil {
+		t.Fatalf("expected no rate limit by default")
+	}
+	if opt := rateLimitOption(Options{RateBurst: 5}, config{}); opt != nil {
+		t.Fatalf("expected no rate limit without a rate")
+	}
+	if opt := rateLimitOption(Options{}, config{RateLimit: 2, RateBurst: 5}); opt == nil {
+		t.Fatalf("expected rate limit from the config file")
+	}
+	if opt := rateLimitOption(Options{RateLimit: 2}, config{}); opt == nil {
+		t.Fatalf("expected rate limit from the command line")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DCSO/gotie/v1"
+	"github.com/DCSO/gotie/v1/store"
+)
+
+// feedPeriods are the update windows of the feed periods in offline mode
+var feedPeriods = map[string]time.Duration{
+	"hourly":  time.Hour,
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+}
+
+// storeQuery builds a local store query from the filter options shared by
+// the iocs and feed verbs
+func storeQuery(params Params) (q store.Query, err error) {
+	q.Value = param(params, "Query")
+	q.DataType = param(params, "DataType")
+
+	for _, r := range []struct {
+		name  string
+		field **gotie.Range
+	}{
+		{"Severity", &q.Severity},
+		{"Confidence", &q.Confidence},
+	} {
+		if s := param(params, r.name); s != "" {
+			rng, err := gotie.ParseRange(s)
+			if err != nil {
+				return q, err
+			}
+			*r.field = &rng
+		}
+	}
+
+	for _, l := range []struct {
+		name  string
+		field *[]string
+	}{
+		{"Category", &q.Categories},
+		{"Source_pseudonym", &q.SourcePseudonyms},
+	} {
+		for _, item := range strings.Split(param(params, l.name), ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*l.field = append(*l.field, item)
+			}
+		}
+	}
+
+	for _, date := range []struct {
+		name  string
+		field *time.Time
+	}{
+		{"Updated_since", &q.Updated.Since},
+		{"Updated_until", &q.Updated.Until},
+		{"Created_since", &q.Created.Since},
+		{"Created_until", &q.Created.Until},
+		{"First_seen_since", &q.FirstSeen.Since},
+		{"First_seen_until", &q.FirstSeen.Until},
+		{"Last_seen_since", &q.LastSeen.Since},
+		{"Last_seen_until", &q.LastSeen.Until},
+	} {
+		if s := param(params, date.name); s != "" {
+			if *date.field, err = parseTime(s); err != nil {
+				return q, err
+			}
+		}
+	}
+
+	if period := param(params, "Period"); period != "" {
+		d, ok := feedPeriods[period]
+		if !ok {
+			return q, fmt.Errorf("invalid feed period %q", period)
+		}
+		q.Updated.Since = time.Now().Add(-d)
+	}
+
+	return q, nil
+}
+
+// queryStore writes the IOCs of the local store selected by the options in
+// params to stdout, in output format t.
+func queryStore(params Params, t gotie.MimeType, debug bool) (err error) {
+	q, err := storeQuery(params)
+	if err != nil {
+		return err
+	}
+
+	s, err := store.OpenReadOnly(param(params, "Store"))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	iocs, err := s.Query(q)
+	if err != nil {
+		return err
+	}
+
+	iw, closeFiles, err := newWriter(t, params, os.Stdout, true, debugLogger(debug))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := closeFiles(); err == nil {
+			err = cerr
+		}
+	}()
+
+	for i := range iocs {
+		if err := iw.WriteIOC(&iocs[i]); err != nil {
+			return err
+		}
+	}
+	return iw.Close()
+}
@@ -0,0 +1,251 @@
+package main
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DCSO/gotie/v1"
+)
+
+type PingBackParams struct {
+	DataType string `goptions:"-t,--type, description='Specify a valid TIE IOC data type'"`
+	Value    string `goptions:"-v,--value, description='Specify a valid TIE IOC data value'"`
+	Input    string `goptions:"-i,--input, description='Submit the sightings of a file with data_type,value[,seen] or NDJSON lines'"`
+	Stdin    bool   `goptions:"--stdin, description='Submit the sightings read from stdin, like --input'"`
+	JSON     bool   `goptions:"--json, description='Print the result as JSON'"`
+}
+
+// pingbackProgress is the number of sightings after which the progress of
+// a file submission is logged
+const pingbackProgress = 1000
+
+// pingbackOutput is the outcome of a submission as printed by the pingback
+// verb
+type pingbackOutput struct {
+	Line       int         `json:"line,omitempty"`
+	DataType   string      `json:"data_type"`
+	Value      string      `json:"value"`
+	OK         bool        `json:"ok"`
+	StatusCode int         `json:"status_code"`
+	Message    string      `json:"message,omitempty"`
+	Errors     interface{} `json:"errors,omitempty"`
+}
+
+// newPingbackOutput returns the output for the submission of s
+func newPingbackOutput(s gotie.Sighting, resp *gotie.PingbackResponse, err error) pingbackOutput {
+	out := pingbackOutput{DataType: s.DataType, Value: s.Value}
+	if perr, ok := err.(*gotie.APIError); ok {
+		out.StatusCode = perr.StatusCode
+		out.Message = perr.Message
+		out.Errors = perr.Errors
+	} else if err != nil {
+		out.Message = err.Error()
+	} else {
+		out.OK = true
+		out.StatusCode = resp.StatusCode
+		out.Message = resp.Message
+	}
+	return out
+}
+
+// write prints out to w as JSON or as concise line
+func (out *pingbackOutput) write(w io.Writer, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(out)
+	}
+
+	var line string
+	switch {
+	case out.OK:
+		line = "submitted"
+	case out.StatusCode != 0:
+		line = "rejected"
+	default:
+		line = "failed"
+	}
+	if out.Line > 0 {
+		line += fmt.Sprintf(" line %d", out.Line)
+	}
+	if out.DataType != "" || out.Value != "" {
+		line += fmt.Sprintf(" %s %s", out.DataType, out.Value)
+	}
+	if out.StatusCode != 0 {
+		line += fmt.Sprintf(" (%d)", out.StatusCode)
+	}
+	if out.Message != "" {
+		line += ": " + out.Message
+	}
+	if out.Errors != nil {
+		line += fmt.Sprintf(" %v", out.Errors)
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+// parseSighting parses a sighting line, either a JSON object with the
+// fields data_type, value and seen or a CSV record data_type,value[,seen].
+// The data type is validated and returned in TIE spelling.
+func parseSighting(line string) (s gotie.Sighting, err error) {
+	var seen string
+	if strings.HasPrefix(line, "{") {
+		var rec struct {
+			DataType string `json:"data_type"`
+			Value    string `json:"value"`
+			Seen     string `json:"seen"`
+		}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return s, err
+		}
+		s.DataType, s.Value, seen = rec.DataType, rec.Value, rec.Seen
+	} else {
+		r := csv.NewReader(strings.NewReader(line))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		fields, err := r.Read()
+		if err != nil {
+			return s, err
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return s, fmt.Errorf("expected data_type,value[,seen], got %d fields", len(fields))
+		}
+		s.DataType, s.Value = fields[0], fields[1]
+		if len(fields) == 3 {
+			seen = fields[2]
+		}
+	}
+
+	dataType, ok := gotie.CanonicalDataType(strings.TrimSpace(s.DataType))
+	if !ok {
+		return s, fmt.Errorf("unknown data type %q", s.DataType)
+	}
+	s.DataType = dataType
+	if s.Value = strings.TrimSpace(s.Value); s.Value == "" {
+		return s, errors.New("empty value")
+	}
+	if seen = strings.TrimSpace(seen); seen != "" {
+		if s.Seen, err = parseTime(seen); err != nil {
+			return s, fmt.Errorf("invalid seen time %q", seen)
+		}
+	}
+	return s, nil
+}
+
+// submitSightings submits the sightings read from r with a batcher of
+// client, printing failed entries to w. It reports whether all entries
+// were accepted.
+func submitSightings(ctx context.Context, client *gotie.Client, r io.Reader, w io.Writer, asJSON bool) (bool, error) {
+	var mu sync.Mutex
+	var werr error
+	var submitted, duplicates, failed int
+
+	fail := func(out pingbackOutput) {
+		failed++
+		if err := out.write(w, asJSON); err != nil && werr == nil {
+			werr = err
+		}
+	}
+
+	b := client.NewPingbackBatcherContext(ctx, gotie.WithResultFunc(func(res gotie.PingbackResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case res.Duplicate:
+			duplicates++
+		case res.Error != nil:
+			fail(newPingbackOutput(res.Sighting, res.Response, res.Error))
+		default:
+			submitted++
+		}
+		if n := submitted + duplicates + failed; n%pingbackProgress == 0 {
+			log.Printf("pingback: %d sightings processed", n)
+		}
+	}))
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || (n == 1 && strings.HasPrefix(line, "data_type,")) {
+			continue
+		}
+
+		s, err := parseSighting(line)
+		if err != nil {
+			mu.Lock()
+			fail(pingbackOutput{Line: n, Message: err.Error()})
+			mu.Unlock()
+			continue
+		}
+		if s.Seen.IsZero() {
+			s.Seen = time.Now()
+		}
+		if err := b.Add(s); err != nil {
+			b.Close()
+			return false, err
+		}
+	}
+	b.Close()
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	if werr != nil {
+		return false, werr
+	}
+
+	log.Printf("pingback: %d submitted, %d duplicates, %d failed", submitted, duplicates, failed)
+	return failed == 0, ctx.Err()
+}
+
+// submitPingback submits the sighting given in params and prints the
+// outcome to w. It reports whether TIE accepted the sighting; other
+// failures are returned as error.
+func submitPingback(ctx context.Context, client *gotie.Client, params PingBackParams, w io.Writer) (bool, error) {
+	s := gotie.Sighting{DataType: params.DataType, Value: params.Value}
+	if dataType, ok := gotie.CanonicalDataType(s.DataType); ok {
+		s.DataType = dataType
+	} else {
+		return false, fmt.Errorf("unknown data type %q", s.DataType)
+	}
+
+	resp, err := client.SubmitSightingContext(ctx, s)
+	if _, ok := err.(*gotie.APIError); err != nil && !ok {
+		return false, err
+	}
+
+	out := newPingbackOutput(s, resp, err)
+	return out.OK, out.write(w, params.JSON)
+}
+
+// pingback submits the sightings selected by params, either a single one
+// or those of a file or stdin, and reports whether all were accepted
+func pingback(ctx context.Context, client *gotie.Client, params PingBackParams, w io.Writer) (bool, error) {
+	switch {
+	case params.Input != "":
+		f, err := os.Open(params.Input)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		return submitSightings(ctx, client, f, w, params.JSON)
+	case params.Stdin:
+		return submitSightings(ctx, client, os.Stdin, w, params.JSON)
+	case params.DataType != "" && params.Value != "":
+		return submitPingback(ctx, client, params, w)
+	default:
+		return false, errors.New("pingback needs --type and --value, --input or --stdin")
+	}
+}
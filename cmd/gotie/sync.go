@@ -0,0 +1,107 @@
+package main
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/DCSO/gotie/v1"
+	"github.com/DCSO/gotie/v1/store"
+)
+
+type SyncParams struct {
+	State            string `goptions:"-s,--state, description='State file holding the time of the last update seen'"`
+	Store            string `goptions:"-o,--store, description='JSON file the IOCs are stored in'"`
+	Log_store        string `goptions:"-l,--log-store, description='Also keep the IOCs in the given local store for offline queries'"`
+	Expire           string `goptions:"--expire, description='Remove IOCs not seen for the given number of days from the local store'"`
+	Query            string `goptions:"-q,--query, description='Query string (case insensitive)'"`
+	DataType         string `goptions:"-t,--type, description='TIE IOC data type'"`
+	Category         string `goptions:"-c,--category, description='specify comma-separated IOC categories'"`
+	Severity         string `goptions:"--severity, description='Specify severity (can be a range)'"`
+	Source_pseudonym string `goptions:"--source, description='Specify source pseudonym'"`
+	Confidence       string `goptions:"--confidence, description='Specify confidence (can be a range)'"`
+	Limit            string `goptions:"--limit, description='Specify limit of IOCs to query at once'"`
+	Updated_since    string `goptions:"--updated-since, description='Start of the first sync'"`
+}
+
+// runSync fetches the IOCs updated since the last run into the store file
+func runSync(ctx context.Context, clientOpts []gotie.ClientOption, params SyncParams, debug bool) (err error) {
+	limit, err := strconv.ParseInt(params.Limit, 10, 32)
+	if err != nil {
+		return err
+	}
+	client := gotie.NewClient(append(clientOpts, gotie.WithIOCLimit(int(limit)))...)
+
+	args, err := buildQuery(params, debug)
+	if err != nil {
+		return err
+	}
+	jsonStore, err := gotie.OpenJSONFileStore(params.Store)
+	if err != nil {
+		return err
+	}
+
+	stores := multiStore{jsonStore}
+	if params.Log_store != "" {
+		var logStore *store.Store
+		if logStore, err = store.Open(params.Log_store); err != nil {
+			return err
+		}
+		defer logStore.Close()
+		stores = append(stores, logStore)
+
+		if params.Expire != "" {
+			var days int
+			if days, err = strconv.Atoi(params.Expire); err != nil {
+				return fmt.Errorf("invalid expiry %q: %v", params.Expire, err)
+			}
+			defer func() {
+				if err != nil {
+					return
+				}
+				var n int
+				if n, err = logStore.Expire(time.Now().AddDate(0, 0, -days)); err == nil {
+					fmt.Printf("%d IOCs expired\n", n)
+				}
+			}()
+		}
+	}
+
+	syncer := gotie.NewSyncer(client, stores, params.State)
+	n, err := syncer.SyncContext(ctx, gotie.SyncQuery{
+		Query:    params.Query,
+		DataType: params.DataType,
+		Args:     args,
+	})
+	fmt.Printf("%d IOCs synced\n", n)
+
+	return err
+}
+
+// multiStore stores IOCs in several stores
+type multiStore []gotie.IOCStore
+
+func (ms multiStore) Upsert(iocs []gotie.IOC) error {
+	for _, s := range ms {
+		if err := s.Upsert(iocs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush flushes the stores buffering upserted IOCs
+func (ms multiStore) Flush() error {
+	for _, s := range ms {
+		if f, ok := s.(gotie.IOCFlusher); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/DCSO/bloom"
+)
+
+// DefaultBloomFalsePositiveRate is the false positive rate of locally built
+// bloom filters unless configured otherwise
+const DefaultBloomFalsePositiveRate = 0.001
+
+// NormalizeIOCValue returns the value of ioc in a canonical spelling, so
+// that lookups do not depend on the case or notation used by the source:
+// domain names, email addresses and hashes are lowercased, hashes lose
+// their algorithm prefix, IP addresses are formatted by net.IP and the
+// scheme and host of URLs are lowercased. Other values are only trimmed.
+func NormalizeIOCValue(ioc *IOC) string {
+	value := strings.TrimSpace(ioc.Value)
+	dataType, _ := CanonicalDataType(ioc.DataType)
+
+	switch dataType {
+	case DataTypeDomainName:
+		return strings.ToLower(strings.TrimSuffix(value, "."))
+	case DataTypeEmail:
+		return strings.ToLower(value)
+	case DataTypeExactHash:
+		_, digest := HashAlgorithm(value)
+		return digest
+	case DataTypeIPv4, DataTypeIPv6:
+		if ip := net.ParseIP(value); ip != nil {
+			return ip.String()
+		}
+	case DataTypeURLVerbatim:
+		if i := strings.Index(value, "://"); i >= 0 {
+			end := len(value)
+			if j := strings.IndexAny(value[i+3:], "/?#"); j >= 0 {
+				end = i + 3 + j
+			}
+			return strings.ToLower(value[:end]) + value[end:]
+		}
+	}
+
+	return value
+}
+
+// BloomBuilder builds a DCSO bloom filter locally from IOC values. The
+// values are collected first, so the filter can be sized to fit them.
+type BloomBuilder struct {
+	// Capacity of the filter. If zero, the number of distinct values is
+	// used.
+	Capacity uint64
+	// FalsePositiveRate of the filter
+	FalsePositiveRate float64
+	// Normalize adds the values as returned by NormalizeIOCValue
+	Normalize bool
+	// Base is an existing filter the values are added to. Its capacity and
+	// false positive rate take precedence.
+	Base *bloom.BloomFilter
+	// Logger, if set, is warned when the values exceed the capacity
+	Logger *log.Logger
+
+	values []string
+	seen   map[string]bool
+}
+
+func NewBloomBuilder() *BloomBuilder {
+	return &BloomBuilder{
+		FalsePositiveRate: DefaultBloomFalsePositiveRate,
+		seen:              make(map[string]bool),
+	}
+}
+
+// SetLogger implements LoggerSetter
+func (bb *BloomBuilder) SetLogger(l *log.Logger) {
+	bb.Logger = l
+}
+
+// Add adds the value of ioc
+func (bb *BloomBuilder) Add(ioc *IOC) {
+	value := ioc.Value
+	if bb.Normalize {
+		value = NormalizeIOCValue(ioc)
+	}
+
+	if value == "" || bb.seen[value] {
+		return
+	}
+	if bb.seen == nil {
+		bb.seen = make(map[string]bool)
+	}
+	bb.seen[value] = true
+	bb.values = append(bb.values, value)
+}
+
+// AddChan adds the values of all IOCs received from in. It returns the
+// first error received, after draining the channel.
+func (bb *BloomBuilder) AddChan(in <-chan IOCResult) (err error) {
+	for result := range in {
+		if result.Error != nil {
+			if err == nil {
+				err = result.Error
+			}
+			continue
+		}
+		bb.Add(result.IOC)
+	}
+	return
+}
+
+// Len returns the number of distinct values added so far
+func (bb *BloomBuilder) Len() int {
+	return len(bb.values)
+}
+
+// Filter returns the filter containing all values added so far, and is
+// meant to be called once all values are added. If Base is set, the values
+// are added to it and Base is returned.
+func (bb *BloomBuilder) Filter() *bloom.BloomFilter {
+	f := bb.Base
+	if f == nil {
+		capacity := bb.Capacity
+		if capacity == 0 {
+			capacity = uint64(len(bb.values))
+			if capacity == 0 {
+				capacity = 1
+			}
+		}
+		filter := bloom.Initialize(capacity, bb.FalsePositiveRate)
+		f = &filter
+	}
+
+	if bb.Logger != nil && f.N+uint64(len(bb.values)) > f.MaxNumElements() {
+		bb.Logger.Printf("bloom: adding %d values exceeds the filter capacity of %d", len(bb.values), f.MaxNumElements())
+	}
+
+	for _, value := range bb.values {
+		f.Add([]byte(value))
+	}
+
+	return f
+}
+
+// BuildBloomFilter builds a bloom filter sized for the IOCs received from
+// in, with the given false positive rate.
+func BuildBloomFilter(in <-chan IOCResult, p float64, normalize bool) (*bloom.BloomFilter, error) {
+	bb := NewBloomBuilder()
+	bb.FalsePositiveRate = p
+	bb.Normalize = normalize
+
+	if err := bb.AddChan(in); err != nil {
+		return nil, err
+	}
+
+	return bb.Filter(), nil
+}
+
+// BloomWriter is an IOCWriter collecting IOC values in a BloomBuilder. The
+// filter is written on Close.
+type BloomWriter struct {
+	*BloomBuilder
+
+	w io.Writer
+}
+
+func NewBloomWriter(w io.Writer) *BloomWriter {
+	return &BloomWriter{
+		BloomBuilder: NewBloomBuilder(),
+		w:            w,
+	}
+}
+
+func (bw *BloomWriter) WriteIOC(ioc *IOC) error {
+	bw.Add(ioc)
+	return nil
+}
+
+func (bw *BloomWriter) Close() error {
+	return bw.Filter().Write(bw.w)
+}
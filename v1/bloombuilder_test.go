@@ -0,0 +1,80 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/DCSO/bloom"
+)
+
+func TestNormalizeIOCValue(t *testing.T) {
+	for _, c := range []struct {
+		ioc      IOC
+		expected string
+	}{
+		{IOC{DataType: "DomainName", Value: " WWW.Example.com. "}, "www.example.com"},
+		{IOC{DataType: "ExactHash", Value: "MD5:" + strings.Repeat("AB", 16)}, strings.Repeat("ab", 16)},
+		{IOC{DataType: "IPv6", Value: "2001:DB8:0:0::1"}, "2001:db8::1"},
+		{IOC{DataType: "URLVerbatim", Value: "HTTP://Example.COM/Path?Q"}, "http://example.com/Path?Q"},
+		{IOC{DataType: "Mutex", Value: "Global\\X"}, "Global\\X"},
+	} {
+		if got := NormalizeIOCValue(&c.ioc); got != c.expected {
+			t.Errorf("expected %q for %q, got %q", c.expected, c.ioc.Value, got)
+		}
+	}
+}
+
+func TestBuildBloomFilter(t *testing.T) {
+	in := make(chan IOCResult)
+	go func() {
+		for _, value := range []string{"a.example.com", "B.example.com", "a.example.com"} {
+			in <- IOCResult{IOC: &IOC{DataType: "DomainName", Value: value}}
+		}
+		close(in)
+	}()
+
+	f, err := BuildBloomFilter(in, 0.0001, true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if f.MaxNumElements() != 2 {
+		t.Errorf("expected capacity 2, got %v", f.MaxNumElements())
+	}
+	for _, value := range []string{"a.example.com", "b.example.com"} {
+		if !f.Check([]byte(value)) {
+			t.Errorf("%v missing from filter", value)
+		}
+	}
+}
+
+func TestBloomWriterBase(t *testing.T) {
+	base := bloom.Initialize(100, 0.0001)
+	base.Add([]byte("old.example.com"))
+
+	var out bytes.Buffer
+	bw := NewBloomWriter(&out)
+	bw.Base = &base
+	if err := bw.WriteIOC(&IOC{DataType: "DomainName", Value: "new.example.com"}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	f, err := bloom.LoadFromReader(&out, false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if f.MaxNumElements() != 100 {
+		t.Errorf("expected capacity of the base filter, got %v", f.MaxNumElements())
+	}
+	for _, value := range []string{"old.example.com", "new.example.com"} {
+		if !f.Check([]byte(value)) {
+			t.Errorf("%v missing from filter", value)
+		}
+	}
+}
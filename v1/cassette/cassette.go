@@ -0,0 +1,268 @@
+// Package cassette records HTTP exchanges with TIE and replays them, so
+// sessions can be reproduced without network access. A cassette is a file
+// of JSON lines, one per exchange, with tokens removed.
+package cassette
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// redacted replaces credentials in recorded exchanges
+const redacted = "REDACTED"
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"request_header,omitempty"`
+	RequestBody    string      `json:"request_body,omitempty"`
+	StatusCode     int         `json:"status_code"`
+	Status         string      `json:"status"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	ResponseBody   string      `json:"response_body"`
+	// Base64 is set if ResponseBody is base64 encoded binary data, e.g. a
+	// Bloom filter
+	Base64 bool `json:"base64,omitempty"`
+}
+
+// body returns the decoded response body
+func (i *Interaction) body() ([]byte, error) {
+	if i.Base64 {
+		return base64.StdEncoding.DecodeString(i.ResponseBody)
+	}
+	return []byte(i.ResponseBody), nil
+}
+
+// scrubURL removes user info and the values of token parameters from u
+func scrubURL(u *url.URL) *url.URL {
+	s := *u
+	s.User = nil
+	q := s.Query()
+	changed := false
+	for name := range q {
+		if strings.Contains(strings.ToLower(name), "token") {
+			q.Set(name, redacted)
+			changed = true
+		}
+	}
+	if changed {
+		s.RawQuery = q.Encode()
+	}
+	return &s
+}
+
+// scrubHeader returns a copy of h without credentials
+func scrubHeader(h http.Header) http.Header {
+	s := make(http.Header, len(h))
+	for name, values := range h {
+		switch http.CanonicalHeaderKey(name) {
+		case "Authorization", "Cookie", "Set-Cookie":
+			s[name] = []string{redacted}
+		default:
+			s[name] = append([]string(nil), values...)
+		}
+	}
+	return s
+}
+
+// requestKey identifies a request by method, path, query and body, so
+// recordings can be replayed against other hosts
+func requestKey(method string, u *url.URL, body string) string {
+	return method + " " + scrubURL(u).RequestURI() + "\n" + body
+}
+
+// readRequestBody returns the body of req and restores it for sending
+func readRequestBody(req *http.Request) (string, error) {
+	if req.Body == nil {
+		return "", nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return string(body), nil
+}
+
+// RecordingTransport is an http.RoundTripper passing requests to Transport
+// and writing each exchange to a cassette as soon as it is complete, so
+// even an aborted session is recorded.
+type RecordingTransport struct {
+	// Transport sends the requests, http.DefaultTransport if nil
+	Transport http.RoundTripper
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRecordingTransport returns a RecordingTransport writing the cassette
+// to w
+func NewRecordingTransport(transport http.RoundTripper, w io.Writer) *RecordingTransport {
+	return &RecordingTransport{
+		Transport: transport,
+		enc:       json.NewEncoder(w),
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	i := Interaction{
+		Method:         req.Method,
+		URL:            scrubURL(req.URL).String(),
+		RequestHeader:  scrubHeader(req.Header),
+		RequestBody:    reqBody,
+		StatusCode:     resp.StatusCode,
+		Status:         resp.Status,
+		ResponseHeader: scrubHeader(resp.Header),
+		ResponseBody:   string(body),
+	}
+	if !utf8.Valid(body) {
+		i.ResponseBody = base64.StdEncoding.EncodeToString(body)
+		i.Base64 = true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.enc.Encode(&i); err != nil {
+		return nil, fmt.Errorf("record: %v", err)
+	}
+	return resp, nil
+}
+
+// Read reads the interactions of a cassette
+func Read(r io.Reader) ([]Interaction, error) {
+	var interactions []Interaction
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var i Interaction
+		if err := json.Unmarshal(line, &i); err != nil {
+			return nil, fmt.Errorf("cassette line %d: %v", n, err)
+		}
+		interactions = append(interactions, i)
+	}
+
+	return interactions, scanner.Err()
+}
+
+// ReplayTransport is an http.RoundTripper answering requests with the
+// responses of recorded interactions. Each interaction is replayed once, in
+// recording order among those matching the request's method, path, query
+// and body. The host is ignored, so a session recorded against TIE can be
+// replayed with any API URL.
+type ReplayTransport struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewReplayTransport returns a ReplayTransport replaying interactions
+func NewReplayTransport(interactions []Interaction) *ReplayTransport {
+	return &ReplayTransport{
+		interactions: interactions,
+		used:         make([]bool, len(interactions)),
+	}
+}
+
+// RoundTrip implements http.RoundTripper. Requests without a matching
+// unused interaction fail.
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	key := requestKey(req.Method, req.URL, reqBody)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for n := range t.interactions {
+		i := &t.interactions[n]
+		if t.used[n] {
+			continue
+		}
+		u, err := url.Parse(i.URL)
+		if err != nil || requestKey(i.Method, u, i.RequestBody) != key {
+			continue
+		}
+
+		body, err := i.body()
+		if err != nil {
+			return nil, fmt.Errorf("replay %v %v: %v", i.Method, i.URL, err)
+		}
+		t.used[n] = true
+
+		header := make(http.Header, len(i.ResponseHeader))
+		for name, values := range i.ResponseHeader {
+			header[name] = append([]string(nil), values...)
+		}
+		return &http.Response{
+			Status:        i.Status,
+			StatusCode:    i.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no recorded response for %v %v", req.Method, scrubURL(req.URL))
+}
+
+// Remaining returns the number of interactions not replayed yet
+func (t *ReplayTransport) Remaining() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, used := range t.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,108 @@
+package cassette
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DCSO/gotie/v1"
+	"github.com/DCSO/gotie/v1/tietest"
+)
+
+var formats = []string{"json", "csv", "bloom", "stix", "ndjson"}
+
+// session runs requests in all formats with client and returns the output
+func session(t *testing.T, client *gotie.Client) map[string]string {
+	out := make(map[string]string)
+	for _, format := range formats {
+		var buf bytes.Buffer
+		if err := client.WriteIOCs("example", "domainname", "", format, &buf); err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		out[format] = buf.String()
+	}
+
+	var values []string
+	for res := range client.GetIOCChan("example", "domainname", "") {
+		if res.Error != nil {
+			t.Fatalf(res.Error.Error())
+		}
+		values = append(values, fmt.Sprint(res.IOC))
+	}
+	out["chan"] = strings.Join(values, "\n")
+
+	if _, err := client.SubmitSighting(gotie.Sighting{
+		DataType: "DomainName",
+		Value:    "host1.example.com",
+		Seen:     time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC),
+	}); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	return out
+}
+
+func TestRecordReplay(t *testing.T) {
+	iocs := make([]gotie.IOC, 5)
+	for i := range iocs {
+		iocs[i] = gotie.IOC{ID: fmt.Sprint(i), Value: fmt.Sprintf("host%d.example.com", i), DataType: "DomainName"}
+	}
+	srv := tietest.NewServer(iocs...)
+	srv.SetToken("secret-token")
+	srv.SetPingbackToken("secret-pingback-token")
+	srv.Fail(1, tietest.Failure{StatusCode: http.StatusServiceUnavailable})
+
+	var cassette bytes.Buffer
+	recorder := NewRecordingTransport(srv.Client().Transport, &cassette)
+	recorded := session(t, srv.NewClient(
+		gotie.WithIOCLimit(2),
+		gotie.WithRetries(2, time.Millisecond),
+		gotie.WithHTTPClient(&http.Client{Transport: recorder}),
+	))
+	srv.Close()
+
+	if strings.Contains(cassette.String(), "secret") {
+		t.Fatalf("cassette contains a token:\n%s", cassette.String())
+	}
+
+	interactions, err := Read(&cassette)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	replayer := NewReplayTransport(interactions)
+	replayed := session(t, gotie.NewClient(
+		gotie.WithAPIURL("https://tie.example.com/"),
+		gotie.WithPingbackURL("https://tie.example.com/submit/"),
+		gotie.WithIOCLimit(2),
+		gotie.WithRetries(2, time.Millisecond),
+		gotie.WithPageDelay(0),
+		gotie.WithHTTPClient(&http.Client{Transport: replayer}),
+	))
+
+	for _, name := range append(formats, "chan") {
+		if recorded[name] == "" || replayed[name] != recorded[name] {
+			t.Errorf("%v: replayed %q, recorded %q", name, replayed[name], recorded[name])
+		}
+	}
+	if n := replayer.Remaining(); n != 0 {
+		t.Errorf("%d interactions were not replayed", n)
+	}
+
+	if _, err := replayer.RoundTrip(unrecordedRequest(t)); err == nil {
+		t.Errorf("unrecorded request was answered")
+	}
+}
+
+func unrecordedRequest(t *testing.T) *http.Request {
+	req, err := http.NewRequest("GET", "https://tie.example.com/api/v1/iocs?ivalue=other", nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return req
+}
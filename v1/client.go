@@ -0,0 +1,214 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultAPIURL is the base URL of the public TIE API
+	DefaultAPIURL = "https://tie.dcso.de/api/v1/"
+	// DefaultPingbackURL is the URL of the public TIE pingback endpoint
+	DefaultPingbackURL = "https://tie.dcso.de/api/v1/submit/"
+)
+
+// Client talks to a single TIE API endpoint with a fixed set of credentials.
+// Its configuration is set once by NewClient, so a Client can be shared
+// between goroutines, and several clients can be used side by side to talk
+// to different TIE tenants.
+type Client struct {
+	apiURL        string
+	pingbackURL   string
+	authToken     string
+	pingbackToken string
+	iocLimit      int
+	httpClient    *http.Client
+	logger        *log.Logger
+	debug         bool
+	streaming     bool
+	retryPolicy   RetryPolicy
+	pageDelay     time.Duration
+	pacer         *pacer
+	parallelPages int
+	limiter       *RateLimiter
+}
+
+// ClientOption configures a Client created by NewClient
+type ClientOption func(*Client)
+
+// WithAPIURL sets the base URL of the TIE API, e.g. DefaultAPIURL
+func WithAPIURL(apiURL string) ClientOption {
+	return func(c *Client) {
+		c.apiURL = apiURL
+	}
+}
+
+// WithPingbackURL sets the URL of the TIE pingback endpoint
+func WithPingbackURL(pingbackURL string) ClientOption {
+	return func(c *Client) {
+		c.pingbackURL = pingbackURL
+	}
+}
+
+// WithAuthToken sets the token used for IOC and feed queries
+func WithAuthToken(token string) ClientOption {
+	return func(c *Client) {
+		c.authToken = token
+	}
+}
+
+// WithPingbackToken sets the token used for pingback submissions
+func WithPingbackToken(token string) ClientOption {
+	return func(c *Client) {
+		c.pingbackToken = token
+	}
+}
+
+// WithIOCLimit sets the maximum number of IOCs requested per page
+func WithIOCLimit(limit int) ClientOption {
+	return func(c *Client) {
+		c.iocLimit = limit
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for all requests
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithLogger sets the logger used for debug and retry messages
+func WithLogger(logger *log.Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithDebug turns on verbose logging
+func WithDebug(debug bool) ClientOption {
+	return func(c *Client) {
+		c.debug = debug
+	}
+}
+
+// WithStreaming makes Do write CSV, JSON and NDJSON results page by page as
+// they arrive instead of collecting the whole result in memory first
+func WithStreaming(streaming bool) ClientOption {
+	return func(c *Client) {
+		c.streaming = streaming
+	}
+}
+
+// WithRetries sets how often a failing request is attempted and how long to
+// wait after the first failure. The wait time is doubled after each further
+// failure. It is a shorthand for WithRetryPolicy with a BackoffPolicy.
+func WithRetries(maxRetries int, wait time.Duration) ClientOption {
+	return WithRetryPolicy(&BackoffPolicy{MaxAttempts: maxRetries, Wait: wait})
+}
+
+// WithRetryPolicy sets the policy deciding which failed requests are
+// repeated, e.g. a BackoffPolicy. The default is DefaultRetryPolicy; nil
+// disables retries.
+func WithRetryPolicy(p RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retryPolicy = p
+	}
+}
+
+// WithPageDelay sets the minimum time between the starts of two page
+// requests
+func WithPageDelay(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.pageDelay = d
+	}
+}
+
+// WithParallelPages fetches up to n result pages concurrently. After the
+// first page, further pages are requested by offset ahead of time, while
+// the pages are still passed to the aggregators and DoCh in order. Requests
+// are spaced by the page delay as with sequential paging. n <= 1 fetches
+// one page after the other, following TIE's next links.
+func WithParallelPages(n int) ClientOption {
+	return func(c *Client) {
+		c.parallelPages = n
+	}
+}
+
+// WithRateLimit limits the requests of the client, including IOC queries,
+// feeds and pingbacks, to rps per second with bursts of up to burst
+// requests. Use WithRateLimiter to share a limit between clients.
+func WithRateLimit(rps float64, burst int) ClientOption {
+	return WithRateLimiter(NewRateLimiter(rps, burst))
+}
+
+// WithRateLimiter makes the client wait for l before each request. The
+// default is no limit.
+func WithRateLimiter(l *RateLimiter) ClientOption {
+	return func(c *Client) {
+		c.limiter = l
+	}
+}
+
+// NewClient returns a Client talking to the public TIE API, configured by
+// the given options.
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{
+		apiURL:      DefaultAPIURL,
+		pingbackURL: DefaultPingbackURL,
+		iocLimit:    1000,
+		httpClient:  &http.Client{},
+		logger:      log.New(os.Stderr, "", log.LstdFlags),
+		retryPolicy: DefaultRetryPolicy(),
+		pageDelay:   WAIT_DURATION_MILLISECONDS * time.Millisecond,
+		pacer:       &pacer{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.retryPolicy == nil {
+		c.retryPolicy = &BackoffPolicy{MaxAttempts: 1}
+	}
+
+	return c
+}
+
+// defaultClient returns a Client configured from the package level
+// variables, which are used by the package level functions.
+func defaultClient(opts ...ClientOption) *Client {
+	c := NewClient(
+		WithAPIURL(APIURL),
+		WithPingbackURL(PingbackURL),
+		WithAuthToken(AuthToken),
+		WithIOCLimit(IOCLimit),
+		WithDebug(Debug),
+		WithHTTPClient(&client),
+		WithRateLimiter(Limiter),
+	)
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// debugLogger returns the logger in debug mode, nil otherwise
+func (c *Client) debugLogger() *log.Logger {
+	if c.debug {
+		return c.logger
+	}
+	return nil
+}
+
+func (c *Client) debugf(format string, v ...interface{}) {
+	if c.debug {
+		c.logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,62 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func tenantServer(t *testing.T, token, value string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"message": "invalid token"}`)
+			return
+		}
+		if r.URL.Query().Get("limit") != "10" {
+			t.Errorf("expected limit 10, got %v", r.URL.Query().Get("limit"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"params": {}, "iocs": [{"value": %q}], "has_more": false}`, value)
+	}))
+}
+
+func TestClientTenants(t *testing.T) {
+	srvA := tenantServer(t, "token-a", "a.example.com")
+	defer srvA.Close()
+	srvB := tenantServer(t, "token-b", "b.example.com")
+	defer srvB.Close()
+
+	clientA := NewClient(WithAPIURL(srvA.URL+"/"), WithAuthToken("token-a"), WithIOCLimit(10))
+	clientB := NewClient(WithAPIURL(srvB.URL+"/"), WithAuthToken("token-b"), WithIOCLimit(10))
+
+	for client, expected := range map[*Client]string{
+		clientA: "a.example.com",
+		clientB: "b.example.com",
+	} {
+		var buf bytes.Buffer
+		if err := client.WriteIOCs("example", "domainname", "", "json", &buf); err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		var res IOCQueryStruct
+		if err := json.NewDecoder(&buf).Decode(&res); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if len(res.Iocs) != 1 || res.Iocs[0].Value != expected {
+			t.Errorf("expected single IOC %v, got %v", expected, res.Iocs)
+		}
+	}
+
+	wrong := NewClient(WithAPIURL(srvA.URL+"/"), WithAuthToken("token-b"), WithIOCLimit(10))
+	if err := wrong.WriteIOCs("example", "domainname", "", "json", &bytes.Buffer{}); err == nil {
+		t.Errorf("expected error for invalid token")
+	}
+}
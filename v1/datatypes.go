@@ -0,0 +1,91 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"strings"
+)
+
+// TIE IOC data types
+const (
+	DataTypeASN         = "ASN"
+	DataTypeCVE         = "CVE"
+	DataTypeDomainName  = "DomainName"
+	DataTypeEmail       = "Email"
+	DataTypeExactHash   = "ExactHash"
+	DataTypeFileName    = "FileName"
+	DataTypeIPv4        = "IPv4"
+	DataTypeIPv6        = "IPv6"
+	DataTypeMutex       = "Mutex"
+	DataTypeRegistryKey = "RegistryKey"
+	DataTypeURLVerbatim = "URLVerbatim"
+	DataTypeUserAgent   = "UserAgent"
+)
+
+// DataTypes lists the known TIE IOC data types
+var DataTypes = []string{
+	DataTypeASN,
+	DataTypeCVE,
+	DataTypeDomainName,
+	DataTypeEmail,
+	DataTypeExactHash,
+	DataTypeFileName,
+	DataTypeIPv4,
+	DataTypeIPv6,
+	DataTypeMutex,
+	DataTypeRegistryKey,
+	DataTypeURLVerbatim,
+	DataTypeUserAgent,
+}
+
+// CanonicalDataType returns the TIE spelling of the case insensitive data
+// type name dataType, and whether it is a known data type.
+func CanonicalDataType(dataType string) (string, bool) {
+	for _, t := range DataTypes {
+		if strings.EqualFold(t, dataType) {
+			return t, true
+		}
+	}
+	if strings.EqualFold(dataType, "url") {
+		return DataTypeURLVerbatim, true
+	}
+	return dataType, false
+}
+
+// Hash algorithms of ExactHash IOCs
+const (
+	HashMD5    = "MD5"
+	HashSHA1   = "SHA-1"
+	HashSHA256 = "SHA-256"
+	HashSHA512 = "SHA-512"
+)
+
+// HashAlgorithm returns the algorithm and the plain hex digest of an
+// ExactHash value, which may carry an "algorithm:" prefix. An empty
+// algorithm is returned for unrecognised values.
+func HashAlgorithm(value string) (algorithm, digest string) {
+	digest = strings.ToLower(value)
+	if i := strings.IndexByte(digest, ':'); i >= 0 {
+		digest = digest[i+1:]
+	}
+
+	for _, c := range digest {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return "", digest
+		}
+	}
+
+	switch len(digest) {
+	case 32:
+		return HashMD5, digest
+	case 40:
+		return HashSHA1, digest
+	case 64:
+		return HashSHA256, digest
+	case 128:
+		return HashSHA512, digest
+	default:
+		return "", digest
+	}
+}
@@ -0,0 +1,205 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldChange is the change of an IOC field between two snapshots
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// ChangedIOC is an IOC contained in both snapshots with changed fields
+type ChangedIOC struct {
+	Old     IOC           `json:"-"`
+	New     IOC           `json:"-"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// MarshalJSON identifies the IOC by its new ID, data type and value
+func (c ChangedIOC) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		ID       string        `json:"id"`
+		DataType string        `json:"data_type"`
+		Value    string        `json:"value"`
+		Changes  []FieldChange `json:"changes"`
+	}{c.New.ID, c.New.DataType, c.New.Value, c.Changes})
+}
+
+// IOCDiff holds the differences between two IOC snapshots
+type IOCDiff struct {
+	Added   []IOC        `json:"added"`
+	Removed []IOC        `json:"removed"`
+	Changed []ChangedIOC `json:"changed"`
+}
+
+// diffFields are the IOC fields compared by DiffIOCs
+var diffFields = []struct {
+	name  string
+	value func(*IOC) string
+}{
+	{"min_severity", func(ioc *IOC) string { return strconv.Itoa(ioc.MinSeverity) }},
+	{"max_severity", func(ioc *IOC) string { return strconv.Itoa(ioc.MaxSeverity) }},
+	{"min_confidence", func(ioc *IOC) string { return strconv.Itoa(ioc.MinConfidence) }},
+	{"max_confidence", func(ioc *IOC) string { return strconv.Itoa(ioc.MaxConfidence) }},
+	{"categories", func(ioc *IOC) string {
+		categories := append([]string(nil), ioc.Categories...)
+		sort.Strings(categories)
+		return strings.Join(categories, ",")
+	}},
+	{"last_seen", func(ioc *IOC) string {
+		if ioc.LastSeen == nil {
+			return ""
+		}
+		return ioc.LastSeen.UTC().Format(time.RFC3339)
+	}},
+}
+
+// diffValueKey identifies an IOC by data type and normalized value
+func diffValueKey(ioc *IOC) string {
+	dataType, _ := CanonicalDataType(ioc.DataType)
+	return dataType + "\x00" + NormalizeIOCValue(ioc)
+}
+
+// DiffIOCs compares two IOC snapshots. IOCs are matched by ID, or by data
+// type and normalized value if the ID differs. For matched IOCs, changes of
+// the severity, confidence, categories and last seen time are reported.
+// The results are ordered by data type and value.
+func DiffIOCs(old, new []IOC) *IOCDiff {
+	diff := &IOCDiff{}
+
+	byID := make(map[string]int)
+	byValue := make(map[string]int)
+	for i := range old {
+		if old[i].ID != "" {
+			byID[old[i].ID] = i
+		}
+		byValue[diffValueKey(&old[i])] = i
+	}
+
+	matched := make([]bool, len(old))
+	for i := range new {
+		j, ok := byID[new[i].ID]
+		if !ok || new[i].ID == "" || matched[j] {
+			j, ok = byValue[diffValueKey(&new[i])]
+		}
+		if !ok || matched[j] {
+			diff.Added = append(diff.Added, new[i])
+			continue
+		}
+		matched[j] = true
+
+		var changes []FieldChange
+		for _, field := range diffFields {
+			if o, n := field.value(&old[j]), field.value(&new[i]); o != n {
+				changes = append(changes, FieldChange{Field: field.name, Old: o, New: n})
+			}
+		}
+		if len(changes) > 0 {
+			diff.Changed = append(diff.Changed, ChangedIOC{Old: old[j], New: new[i], Changes: changes})
+		}
+	}
+
+	for j := range old {
+		if !matched[j] {
+			diff.Removed = append(diff.Removed, old[j])
+		}
+	}
+
+	sortIOCs := func(iocs []IOC) {
+		sort.SliceStable(iocs, func(a, b int) bool {
+			return diffValueKey(&iocs[a]) < diffValueKey(&iocs[b])
+		})
+	}
+	sortIOCs(diff.Added)
+	sortIOCs(diff.Removed)
+	sort.SliceStable(diff.Changed, func(a, b int) bool {
+		return diffValueKey(&diff.Changed[a].New) < diffValueKey(&diff.Changed[b].New)
+	})
+
+	return diff
+}
+
+// Empty reports whether the snapshots are equal
+func (d *IOCDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// WriteText writes the differences in a line based format, prefixing added
+// IOCs with "+", removed ones with "-" and changed ones with "~".
+func (d *IOCDiff) WriteText(w io.Writer) error {
+	for _, list := range []struct {
+		prefix string
+		iocs   []IOC
+	}{
+		{"+", d.Added},
+		{"-", d.Removed},
+	} {
+		for _, ioc := range list.iocs {
+			if _, err := fmt.Fprintf(w, "%s %s %s %s\n", list.prefix, ioc.DataType, ioc.Value, ioc.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, c := range d.Changed {
+		changes := make([]string, len(c.Changes))
+		for i, change := range c.Changes {
+			changes[i] = fmt.Sprintf("%s %q -> %q", change.Field, change.Old, change.New)
+		}
+		if _, err := fmt.Fprintf(w, "~ %s %s %s: %s\n", c.New.DataType, c.New.Value, c.New.ID,
+			strings.Join(changes, ", ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteJSON writes the differences as a JSON object
+func (d *IOCDiff) WriteJSON(w io.Writer) error {
+	out := *d
+	for _, list := range []*[]IOC{&out.Added, &out.Removed} {
+		if *list == nil {
+			*list = []IOC{}
+		}
+	}
+	if out.Changed == nil {
+		out.Changed = []ChangedIOC{}
+	}
+	return json.NewEncoder(w).Encode(&out)
+}
+
+// WriteCSV writes one CSV row per added or removed IOC and per changed
+// field
+func (d *IOCDiff) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"change", "id", "data_type", "value", "field", "old", "new"})
+
+	for _, ioc := range d.Added {
+		cw.Write([]string{"added", ioc.ID, ioc.DataType, ioc.Value, "", "", ""})
+	}
+	for _, ioc := range d.Removed {
+		cw.Write([]string{"removed", ioc.ID, ioc.DataType, ioc.Value, "", "", ""})
+	}
+	for _, c := range d.Changed {
+		for _, change := range c.Changes {
+			cw.Write([]string{"changed", c.New.ID, c.New.DataType, c.New.Value, change.Field, change.Old, change.New})
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,83 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffIOCs(t *testing.T) {
+	seen := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	later := seen.Add(24 * time.Hour)
+
+	old := []IOC{
+		{ID: "1", DataType: "DomainName", Value: "a.example.com", MaxSeverity: 3, Categories: []string{"c2server", "botnet"}, LastSeen: &seen},
+		{ID: "2", DataType: "DomainName", Value: "b.example.com"},
+		{ID: "3", DataType: "IPv4", Value: "192.0.2.1"},
+	}
+	new := []IOC{
+		{ID: "1", DataType: "DomainName", Value: "a.example.com", MaxSeverity: 4, Categories: []string{"botnet", "c2server"}, LastSeen: &later},
+		{ID: "9", DataType: "IPv4", Value: "192.0.2.1"},
+		{ID: "4", DataType: "DomainName", Value: "c.example.com"},
+	}
+
+	diff := DiffIOCs(old, new)
+	if len(diff.Added) != 1 || diff.Added[0].ID != "4" {
+		t.Errorf("unexpected additions %+v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].ID != "2" {
+		t.Errorf("unexpected removals %+v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || len(diff.Changed[0].Changes) != 2 ||
+		diff.Changed[0].Changes[0] != (FieldChange{"max_severity", "3", "4"}) ||
+		diff.Changed[0].Changes[1].Field != "last_seen" {
+		t.Errorf("unexpected changes %+v", diff.Changed)
+	}
+
+	var text bytes.Buffer
+	if err := diff.WriteText(&text); err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := "+ DomainName c.example.com 4\n" +
+		"- DomainName b.example.com 2\n" +
+		"~ DomainName a.example.com 1: max_severity \"3\" -> \"4\", " +
+		"last_seen \"2018-03-01T00:00:00Z\" -> \"2018-03-02T00:00:00Z\"\n"
+	if text.String() != expected {
+		t.Errorf("unexpected text diff:\n%s", text.String())
+	}
+
+	var out bytes.Buffer
+	if err := diff.WriteCSV(&out); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 5 {
+		t.Errorf("unexpected CSV diff %q", lines)
+	}
+
+	out.Reset()
+	if err := DiffIOCs(nil, nil).WriteJSON(&out); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if strings.TrimSpace(out.String()) != `{"added":[],"removed":[],"changed":[]}` {
+		t.Errorf("unexpected empty JSON diff %q", out.String())
+	}
+
+	out.Reset()
+	if err := diff.WriteJSON(&out); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var doc struct {
+		Changed []struct {
+			ID      string
+			Changes []FieldChange
+		}
+	}
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil || len(doc.Changed) != 1 || doc.Changed[0].ID != "1" {
+		t.Errorf("unexpected JSON diff %q (%v)", out.String(), err)
+	}
+}
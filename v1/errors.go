@@ -0,0 +1,138 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxErrorBody limits the size of error responses read
+const maxErrorBody = 64 * 1024
+
+// APIError is returned for requests TIE answers with an error status. URL
+// is the request URL without credentials. Message and Errors hold TIE's
+// explanation, if it sent one.
+type APIError struct {
+	StatusCode int
+	Status     string
+	URL        string
+	Message    string
+	Errors     interface{}
+	// RetryAfter is the wait requested by TIE before the request is
+	// repeated, if any
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("TIE returned %v for %v", e.Status, e.URL)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Errors != nil {
+		msg += fmt.Sprintf(" %v", e.Errors)
+	}
+	return msg
+}
+
+// Temporary reports whether the request may succeed when repeated, which
+// is the case for rate limiting and server errors
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// newAPIError returns the APIError for the error response resp
+func newAPIError(resp *http.Response) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header, time.Now()),
+	}
+	if resp.Request != nil {
+		e.URL = redactURL(resp.Request.URL)
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	var msg apiMessage
+	if strings.Contains(resp.Header.Get("Content-Type"), string(JSON)) && json.Unmarshal(body, &msg) == nil {
+		e.Message = msg.Message
+		e.Errors = msg.Errors
+	} else {
+		e.Message = string(bytes.TrimSpace(body))
+	}
+
+	return e
+}
+
+// redactURL returns u without user info and token parameters
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	r := *u
+	r.User = nil
+	q := r.Query()
+	redacted := false
+	for name := range q {
+		if strings.Contains(strings.ToLower(name), "token") {
+			q.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if redacted {
+		r.RawQuery = q.Encode()
+	}
+	return r.String()
+}
+
+// apiStatus returns the status code of err if it is an *APIError
+func apiStatus(err error) int {
+	if e, ok := err.(*APIError); ok {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// IsUnauthorized reports whether err is an *APIError for a missing,
+// invalid or insufficient token
+func IsUnauthorized(err error) bool {
+	code := apiStatus(err)
+	return code == http.StatusUnauthorized || code == http.StatusForbidden
+}
+
+// IsNotFound reports whether err is an *APIError for an unknown resource,
+// such as an invalid feed period or data type
+func IsNotFound(err error) bool {
+	return apiStatus(err) == http.StatusNotFound
+}
+
+// IsInvalidRequest reports whether err is an *APIError for invalid query
+// parameters or submitted data
+func IsInvalidRequest(err error) bool {
+	code := apiStatus(err)
+	return code == http.StatusBadRequest || code == http.StatusUnprocessableEntity
+}
+
+// IsRateLimited reports whether err is an *APIError for exceeding TIE's
+// request quota
+func IsRateLimited(err error) bool {
+	return apiStatus(err) == http.StatusTooManyRequests
+}
+
+// IsServerError reports whether err is an *APIError for a failure of TIE
+func IsServerError(err error) bool {
+	return apiStatus(err) >= 500
+}
+
+// PingbackError is the error of submissions rejected by the pingback API.
+//
+// Deprecated: use APIError, which it is an alias of.
+type PingbackError = APIError
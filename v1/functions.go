@@ -6,16 +6,11 @@ package gotie
 // Copyright (c) 2016-2018, DCSO GmbH
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
-	"strings"
-	"time"
 )
 
 const (
@@ -31,40 +26,104 @@ var (
 	IOCLimit = 1000
 	// AuthToken can be generated in the TIE webinterface and is used for authentication
 	AuthToken string
+	// Limiter, if set, limits the requests of all package level functions
+	Limiter *RateLimiter
 
-	APIURL      = "https://tie.dcso.de/api/v1/"
-	PingbackURL = "https://tie.dcso.de/api/v1/submit/"
+	APIURL      = DefaultAPIURL
+	PingbackURL = DefaultPingbackURL
 	client      = http.Client{}
 )
 
-func GetIOCChan(query string, dataType string, extraArgs string) <-chan IOCResult {
-	outchan := make(chan IOCResult)
+func (c *Client) GetIOCChan(query string, dataType string, extraArgs string) <-chan IOCResult {
+	return c.GetIOCChanContext(context.Background(), query, dataType, extraArgs)
+}
 
-	request := &IOCRequest{
+// GetIOCChanContext queries TIE IOC objects and delivers them on the
+// returned channel, which is closed once the query is done or ctx is
+// cancelled. extraArgs is appended to the query string verbatim; use
+// GetIOCChanQuery for typed query parameters.
+func (c *Client) GetIOCChanContext(ctx context.Context, query string, dataType string, extraArgs string) <-chan IOCResult {
+	return c.requestChan(ctx, &IOCRequest{
 		Query:     query,
 		DataType:  dataType,
 		ExtraArgs: extraArgs,
 		MimeType:  JSON,
-	}
+	})
+}
+
+// GetIOCChanQuery is like GetIOCChanContext, but takes the optional query
+// parameters as IOCQuery, which may be nil.
+func (c *Client) GetIOCChanQuery(ctx context.Context, query string, dataType string, args *IOCQuery) <-chan IOCResult {
+	return c.requestChan(ctx, &IOCRequest{
+		Query:    query,
+		DataType: dataType,
+		Args:     args,
+		MimeType: JSON,
+	})
+}
 
-	go DoCh(request, JSON, outchan)
+// requestChan does r in the background and delivers the IOCs on the
+// returned channel
+func (c *Client) requestChan(ctx context.Context, r Request) <-chan IOCResult {
+	// The final error fits into the buffer once all IOCs are read
+	outchan := make(chan IOCResult, 1)
+
+	go c.DoChContext(ctx, r, JSON, outchan)
 
 	return outchan
 }
 
-func GetIOCPeriodFeedChan(feedPeriod string, dataType string, extraArgs string) <-chan IOCResult {
-	outchan := make(chan IOCResult)
+func GetIOCChan(query string, dataType string, extraArgs string) <-chan IOCResult {
+	return defaultClient().GetIOCChan(query, dataType, extraArgs)
+}
 
-	request := &FeedRequest{
+func GetIOCChanContext(ctx context.Context, query string, dataType string, extraArgs string) <-chan IOCResult {
+	return defaultClient().GetIOCChanContext(ctx, query, dataType, extraArgs)
+}
+
+func GetIOCChanQuery(ctx context.Context, query string, dataType string, args *IOCQuery) <-chan IOCResult {
+	return defaultClient().GetIOCChanQuery(ctx, query, dataType, args)
+}
+
+func (c *Client) GetIOCPeriodFeedChan(feedPeriod string, dataType string, extraArgs string) <-chan IOCResult {
+	return c.GetIOCPeriodFeedChanContext(context.Background(), feedPeriod, dataType, extraArgs)
+}
+
+// GetIOCPeriodFeedChanContext gets the feed for the given period and IOC
+// data type and delivers the IOCs on the returned channel, which is closed
+// once the feed is done or ctx is cancelled. extraArgs is appended to the
+// query string verbatim; use GetIOCPeriodFeedChanQuery for typed query
+// parameters.
+func (c *Client) GetIOCPeriodFeedChanContext(ctx context.Context, feedPeriod string, dataType string, extraArgs string) <-chan IOCResult {
+	return c.requestChan(ctx, &FeedRequest{
 		FeedPeriod: feedPeriod,
 		DataType:   dataType,
 		ExtraArgs:  extraArgs,
 		MimeType:   JSON,
-	}
+	})
+}
 
-	go DoCh(request, JSON, outchan)
+// GetIOCPeriodFeedChanQuery is like GetIOCPeriodFeedChanContext, but takes
+// the optional query parameters as IOCQuery, which may be nil.
+func (c *Client) GetIOCPeriodFeedChanQuery(ctx context.Context, feedPeriod string, dataType string, args *IOCQuery) <-chan IOCResult {
+	return c.requestChan(ctx, &FeedRequest{
+		FeedPeriod: feedPeriod,
+		DataType:   dataType,
+		Args:       args,
+		MimeType:   JSON,
+	})
+}
 
-	return outchan
+func GetIOCPeriodFeedChan(feedPeriod string, dataType string, extraArgs string) <-chan IOCResult {
+	return defaultClient().GetIOCPeriodFeedChan(feedPeriod, dataType, extraArgs)
+}
+
+func GetIOCPeriodFeedChanContext(ctx context.Context, feedPeriod string, dataType string, extraArgs string) <-chan IOCResult {
+	return defaultClient().GetIOCPeriodFeedChanContext(ctx, feedPeriod, dataType, extraArgs)
+}
+
+func GetIOCPeriodFeedChanQuery(ctx context.Context, feedPeriod string, dataType string, args *IOCQuery) <-chan IOCResult {
+	return defaultClient().GetIOCPeriodFeedChanQuery(ctx, feedPeriod, dataType, args)
 }
 
 func GetIOCJSONInChan(reader io.Reader) (<-chan IOCResult, error) {
@@ -100,20 +159,85 @@ func IOCChanCollect(inchan <-chan IOCResult) (*IOCQueryStruct, error) {
 	return &outData, nil
 }
 
+// GetIOCs allows queries for TIE IOC objects with "query" being a case
+// insensitive string to search for. extraArgs is appended to the query
+// string verbatim; use GetIOCsQuery for typed query parameters.
+func (c *Client) GetIOCs(query string, dataType string, extraArgs string) (*IOCQueryStruct, error) {
+	return c.GetIOCsContext(context.Background(), query, dataType, extraArgs)
+}
+
+// GetIOCsContext is like GetIOCs but aborts the query when ctx is cancelled.
+func (c *Client) GetIOCsContext(ctx context.Context, query string, dataType string, extraArgs string) (*IOCQueryStruct, error) {
+	return IOCChanCollect(c.GetIOCChanContext(ctx, query, dataType, extraArgs))
+}
+
+// GetIOCsQuery is like GetIOCsContext, but takes the optional query
+// parameters as IOCQuery, which may be nil.
+func (c *Client) GetIOCsQuery(ctx context.Context, query string, dataType string, args *IOCQuery) (*IOCQueryStruct, error) {
+	return IOCChanCollect(c.GetIOCChanQuery(ctx, query, dataType, args))
+}
+
 // GetIOCs allows queries for TIE IOC objects with "query" being a case
 // insensitive string to search for.
 func GetIOCs(query string, dataType string, extraArgs string) (*IOCQueryStruct, error) {
-	return IOCChanCollect(GetIOCChan(query, dataType, extraArgs))
+	return defaultClient().GetIOCs(query, dataType, extraArgs)
+}
+
+// GetIOCsContext is like GetIOCs but aborts the query when ctx is cancelled.
+func GetIOCsContext(ctx context.Context, query string, dataType string, extraArgs string) (*IOCQueryStruct, error) {
+	return defaultClient().GetIOCsContext(ctx, query, dataType, extraArgs)
+}
+
+// GetIOCsQuery is like GetIOCsContext, but takes the optional query
+// parameters as IOCQuery, which may be nil.
+func GetIOCsQuery(ctx context.Context, query string, dataType string, args *IOCQuery) (*IOCQueryStruct, error) {
+	return defaultClient().GetIOCsQuery(ctx, query, dataType, args)
 }
 
 // GetIOCPeriodFeeds gets file based feeds for the given period and IOC data type.
 // Feed types are, for example, 'hourly', 'daily', 'weekly' or 'monthly'.
-func GetIOCPeriodFeeds(feedPeriod string, dataType string, extraArgs string) (*IOCQueryStruct, error) {
-	tmp := GetIOCPeriodFeedChan(feedPeriod, dataType, extraArgs)
+// extraArgs is appended to the query string verbatim; use
+// GetIOCPeriodFeedsQuery for typed query parameters.
+func (c *Client) GetIOCPeriodFeeds(feedPeriod string, dataType string, extraArgs string) (*IOCQueryStruct, error) {
+	return c.GetIOCPeriodFeedsContext(context.Background(), feedPeriod, dataType, extraArgs)
+}
+
+// GetIOCPeriodFeedsContext is like GetIOCPeriodFeeds but aborts the query
+// when ctx is cancelled.
+func (c *Client) GetIOCPeriodFeedsContext(ctx context.Context, feedPeriod string, dataType string, extraArgs string) (*IOCQueryStruct, error) {
+	tmp := c.GetIOCPeriodFeedChanContext(ctx, feedPeriod, dataType, extraArgs)
 	return IOCChanCollect(tmp)
 }
 
-func WriteIOCs(query, dataType, extraArgs, outputFormat string, dest io.Writer) (err error) {
+// GetIOCPeriodFeedsQuery is like GetIOCPeriodFeedsContext, but takes the
+// optional query parameters as IOCQuery, which may be nil.
+func (c *Client) GetIOCPeriodFeedsQuery(ctx context.Context, feedPeriod string, dataType string, args *IOCQuery) (*IOCQueryStruct, error) {
+	return IOCChanCollect(c.GetIOCPeriodFeedChanQuery(ctx, feedPeriod, dataType, args))
+}
+
+// GetIOCPeriodFeeds gets file based feeds for the given period and IOC data type.
+// Feed types are, for example, 'hourly', 'daily', 'weekly' or 'monthly'.
+func GetIOCPeriodFeeds(feedPeriod string, dataType string, extraArgs string) (*IOCQueryStruct, error) {
+	return defaultClient().GetIOCPeriodFeeds(feedPeriod, dataType, extraArgs)
+}
+
+// GetIOCPeriodFeedsContext is like GetIOCPeriodFeeds but aborts the query
+// when ctx is cancelled.
+func GetIOCPeriodFeedsContext(ctx context.Context, feedPeriod string, dataType string, extraArgs string) (*IOCQueryStruct, error) {
+	return defaultClient().GetIOCPeriodFeedsContext(ctx, feedPeriod, dataType, extraArgs)
+}
+
+// GetIOCPeriodFeedsQuery is like GetIOCPeriodFeedsContext, but takes the
+// optional query parameters as IOCQuery, which may be nil.
+func GetIOCPeriodFeedsQuery(ctx context.Context, feedPeriod string, dataType string, args *IOCQuery) (*IOCQueryStruct, error) {
+	return defaultClient().GetIOCPeriodFeedsQuery(ctx, feedPeriod, dataType, args)
+}
+
+func (c *Client) WriteIOCs(query, dataType, extraArgs, outputFormat string, dest io.Writer) (err error) {
+	return c.WriteIOCsContext(context.Background(), query, dataType, extraArgs, outputFormat, dest)
+}
+
+func (c *Client) WriteIOCsContext(ctx context.Context, query, dataType, extraArgs, outputFormat string, dest io.Writer) (err error) {
 	t, err := NewMimeType(outputFormat)
 	if err != nil {
 		return
@@ -126,10 +250,44 @@ func WriteIOCs(query, dataType, extraArgs, outputFormat string, dest io.Writer)
 		MimeType:  t,
 	}
 
-	return Do(request, request.MimeType, dest)
+	return c.DoContext(ctx, request, request.MimeType, dest)
 }
 
-func WritePeriodFeeds(feedPeriod string, dataType string, extraArgs string, outputFormat string, dest io.Writer) error {
+// WriteIOCsQuery is like WriteIOCsContext, but takes the optional query
+// parameters as IOCQuery, which may be nil.
+func (c *Client) WriteIOCsQuery(ctx context.Context, query, dataType string, args *IOCQuery, outputFormat string, dest io.Writer) (err error) {
+	t, err := NewMimeType(outputFormat)
+	if err != nil {
+		return
+	}
+
+	request := &IOCRequest{
+		Query:    query,
+		DataType: dataType,
+		Args:     args,
+		MimeType: t,
+	}
+
+	return c.DoContext(ctx, request, request.MimeType, dest)
+}
+
+func WriteIOCs(query, dataType, extraArgs, outputFormat string, dest io.Writer) (err error) {
+	return defaultClient().WriteIOCs(query, dataType, extraArgs, outputFormat, dest)
+}
+
+func WriteIOCsContext(ctx context.Context, query, dataType, extraArgs, outputFormat string, dest io.Writer) (err error) {
+	return defaultClient().WriteIOCsContext(ctx, query, dataType, extraArgs, outputFormat, dest)
+}
+
+func WriteIOCsQuery(ctx context.Context, query, dataType string, args *IOCQuery, outputFormat string, dest io.Writer) (err error) {
+	return defaultClient().WriteIOCsQuery(ctx, query, dataType, args, outputFormat, dest)
+}
+
+func (c *Client) WritePeriodFeeds(feedPeriod string, dataType string, extraArgs string, outputFormat string, dest io.Writer) error {
+	return c.WritePeriodFeedsContext(context.Background(), feedPeriod, dataType, extraArgs, outputFormat, dest)
+}
+
+func (c *Client) WritePeriodFeedsContext(ctx context.Context, feedPeriod string, dataType string, extraArgs string, outputFormat string, dest io.Writer) error {
 	t, err := NewMimeType(outputFormat)
 	if err != nil {
 		return err
@@ -142,7 +300,37 @@ func WritePeriodFeeds(feedPeriod string, dataType string, extraArgs string, outp
 		MimeType:   t,
 	}
 
-	return Do(request, request.MimeType, dest)
+	return c.DoContext(ctx, request, request.MimeType, dest)
+}
+
+// WritePeriodFeedsQuery is like WritePeriodFeedsContext, but takes the
+// optional query parameters as IOCQuery, which may be nil.
+func (c *Client) WritePeriodFeedsQuery(ctx context.Context, feedPeriod string, dataType string, args *IOCQuery, outputFormat string, dest io.Writer) error {
+	t, err := NewMimeType(outputFormat)
+	if err != nil {
+		return err
+	}
+
+	request := &FeedRequest{
+		FeedPeriod: feedPeriod,
+		DataType:   dataType,
+		Args:       args,
+		MimeType:   t,
+	}
+
+	return c.DoContext(ctx, request, request.MimeType, dest)
+}
+
+func WritePeriodFeeds(feedPeriod string, dataType string, extraArgs string, outputFormat string, dest io.Writer) error {
+	return defaultClient().WritePeriodFeeds(feedPeriod, dataType, extraArgs, outputFormat, dest)
+}
+
+func WritePeriodFeedsContext(ctx context.Context, feedPeriod string, dataType string, extraArgs string, outputFormat string, dest io.Writer) error {
+	return defaultClient().WritePeriodFeedsContext(ctx, feedPeriod, dataType, extraArgs, outputFormat, dest)
+}
+
+func WritePeriodFeedsQuery(ctx context.Context, feedPeriod string, dataType string, args *IOCQuery, outputFormat string, dest io.Writer) error {
+	return defaultClient().WritePeriodFeedsQuery(ctx, feedPeriod, dataType, args, outputFormat, dest)
 }
 
 // PrintIOCs allows queries for TIE IOC objects with "query" being a case
@@ -157,38 +345,28 @@ func PrintPeriodFeeds(feedPeriod string, dataType string, extraArgs string, outp
 	return WritePeriodFeeds(feedPeriod, dataType, extraArgs, outputFormat, os.Stdout)
 }
 
-// PingBackCall allows to tell the TIE about observed hits for IOCs
-func PingBackCall(dataType string, value string, token string) error {
-	currentDate := time.Now().UTC().Format(time.RFC3339)
-
-	form := url.Values{}
-	form.Add("data_type", dataType)
-	form.Add("value", value)
-	form.Add("seen", currentDate)
-
-	req, err := http.NewRequest("POST", PingbackURL, strings.NewReader(form.Encode()))
-	if err != nil {
-		return err
-	}
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Add("Authorization", "Bearer "+token)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+// PingBackCall allows to tell the TIE about observed hits for IOCs. The
+// client's pingback token is used for authentication. Submissions rejected
+// by TIE are returned as *APIError; use SubmitSighting for the
+// response and to set the observation time.
+func (c *Client) PingBackCall(dataType string, value string) error {
+	return c.PingBackCallContext(context.Background(), dataType, value)
+}
 
-	if Debug {
-		log.Println("Tried URL:" + APIURL + "submit")
-		log.Println("Requested body data:", form.Encode())
-	}
+// PingBackCallContext is like PingBackCall but aborts the submission when
+// ctx is cancelled.
+func (c *Client) PingBackCallContext(ctx context.Context, dataType string, value string) error {
+	_, err := c.SubmitSightingContext(ctx, Sighting{DataType: dataType, Value: value})
+	return err
+}
 
-	dump, err := httputil.DumpResponse(resp, true)
-	if err != nil {
-		return err
-	}
-	fmt.Println(string(dump))
+// PingBackCall allows to tell the TIE about observed hits for IOCs
+func PingBackCall(dataType string, value string, token string) error {
+	return defaultClient(WithPingbackToken(token)).PingBackCall(dataType, value)
+}
 
-	return nil
+// PingBackCallContext is like PingBackCall but aborts the submission when
+// ctx is cancelled.
+func PingBackCallContext(ctx context.Context, dataType string, value string, token string) error {
+	return defaultClient(WithPingbackToken(token)).PingBackCallContext(ctx, dataType, value)
 }
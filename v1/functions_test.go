@@ -1,4 +1,4 @@
-package gotie
+package gotie_test
 
 // DCSO gotie API bindings
 // Copyright (c) 2016-2018, DCSO GmbH
@@ -9,24 +9,67 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
+
+	. "github.com/DCSO/gotie/v1"
+	"github.com/DCSO/gotie/v1/tietest"
 )
 
-func init() {
-	// Get the test token from an environment variable.
-	// This way we don't have to store it in the repository.
-	AuthToken = os.Getenv("TIE_TOKEN")
+// testIOCs returns the IOCs served by the fake TIE of the tests
+func testIOCs() []IOC {
+	firstSeen := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	updated := time.Now().Add(-10 * time.Minute)
+
+	var iocs []IOC
+	for i, v := range []struct{ dataType, value string }{
+		{"DomainName", "www.google.com"},
+		{"DomainName", "mail.google.com"},
+		{"DomainName", "google.example.net"},
+		{"DomainName", "www.example.org"},
+		{"IPv4", "192.0.2.1"},
+	} {
+		iocs = append(iocs, IOC{
+			ID:         fmt.Sprint(i + 1),
+			DataType:   v.dataType,
+			Value:      v.value,
+			Categories: []string{"c2server"},
+			FirstSeen:  &firstSeen,
+			UpdatedAt:  &updated,
+		})
+	}
+	return iocs
+}
+
+// useServer points the package level configuration to a fake TIE serving
+// testIOCs. The returned function restores the configuration.
+func useServer() func() {
+	srv := tietest.NewServer(testIOCs()...)
+	srv.SetToken("secret")
+
+	apiURL, token := APIURL, AuthToken
+	APIURL, AuthToken = srv.APIURL(), "secret"
+
+	return func() {
+		APIURL, AuthToken = apiURL, token
+		srv.Close()
+	}
 }
 
 // TestGetIocs tests all currently supported params for the iocs endpoint
 // of the TIE API.
 func TestGetIocs(t *testing.T) {
+	defer useServer()()
 	var err error
 
-	_, err = GetIOCs("google", "DomainName", "")
+	res, err := GetIOCs("google", "DomainName", "")
 	if err != nil {
 		t.Logf("ERROR: %v", err)
 		t.FailNow()
 	}
+	if len(res.Iocs) != 3 {
+		t.Logf("ERROR: expected 3 IOCs, got %v", res.Iocs)
+		t.FailNow()
+	}
 
 	_, err = GetIOCs("google", "IPv4", "")
 	if err != nil {
@@ -95,6 +138,7 @@ func TestGetIocs(t *testing.T) {
 }
 
 func TestWriteIocs(t *testing.T) {
+	defer useServer()()
 	var info os.FileInfo
 	var err error
 
@@ -123,6 +167,7 @@ func TestWriteIocs(t *testing.T) {
 }
 
 func TestReadWriteIocsJSON(t *testing.T) {
+	defer useServer()()
 	var err error
 	var jsonchan <-chan IOCResult
 	var res *IOCQueryStruct
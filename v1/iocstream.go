@@ -0,0 +1,82 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// readIOCPage reads a JSON result page from r and calls emit for each
+// element of its iocs array in turn. The IOCs are decoded one by one from
+// the token stream, each into a new IOC, so the whole array is never held
+// as decoded objects. The page metadata is decoded before the first IOC is
+// emitted, regardless of where it appears in the page.
+func readIOCPage(r io.Reader, emit func(*IOC, *IOCPage) error) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	// The iocs field is not part of IOCPage and is skipped here
+	page := new(IOCPage)
+	if err := json.Unmarshal(data, page); err != nil {
+		return fmt.Errorf("parse IOC page: %v", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("parse IOC page: %v", err)
+		}
+		if key, _ := tok.(string); key != "iocs" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return fmt.Errorf("parse IOC page: %v", err)
+			}
+			continue
+		}
+
+		tok, err = dec.Token()
+		if err != nil {
+			return fmt.Errorf("parse IOC page: %v", err)
+		} else if tok == nil {
+			continue
+		} else if tok != json.Delim('[') {
+			return fmt.Errorf("parse IOC page: iocs is %v, not an array", tok)
+		}
+		for dec.More() {
+			ioc := new(IOC)
+			if err := dec.Decode(ioc); err != nil {
+				return fmt.Errorf("parse IOC page: %v", err)
+			}
+			if err := emit(ioc, page); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+// expectDelim reads the delimiter d from dec
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("parse IOC page: %v", err)
+	} else if tok != d {
+		return fmt.Errorf("parse IOC page: expected %v, got %v", d, tok)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IOCWriter writes IOCs in an output format which is generated locally
+// from the IOC data rather than by TIE.
+type IOCWriter interface {
+	// WriteIOC converts and writes a single IOC. IOCs of data types which
+	// can not be represented in the output format are skipped.
+	WriteIOC(*IOC) error
+	// Close completes the output. It does not close the underlying writer.
+	Close() error
+}
+
+// IOCWriterAggregator decodes JSON result pages and passes every IOC to an
+// IOCWriter as soon as the page arrives.
+type IOCWriterAggregator struct {
+	newWriter func(io.Writer) IOCWriter
+	w         io.Writer
+	iw        IOCWriter
+}
+
+// NewIOCWriterAggregator returns an aggregator writing to w using the
+// IOCWriter created by newWriter.
+func NewIOCWriterAggregator(w io.Writer, newWriter func(io.Writer) IOCWriter) *IOCWriterAggregator {
+	return &IOCWriterAggregator{
+		newWriter: newWriter,
+		w:         w,
+		iw:        newWriter(w),
+	}
+}
+
+func (pa *IOCWriterAggregator) AddPage(reader io.Reader) error {
+	var tlr JSONTopLevelResponse
+
+	if err := json.NewDecoder(reader).Decode(&tlr); err != nil {
+		return err
+	}
+
+	for i := range tlr.IOCs {
+		if err := pa.iw.WriteIOC(&tlr.IOCs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pa *IOCWriterAggregator) Finish(writer io.Writer) error {
+	return pa.iw.Close()
+}
+
+func (pa *IOCWriterAggregator) Reset() {
+	pa.iw = pa.newWriter(pa.w)
+}
+
+// logSkipped tells l, if set, that the writer for format skipped ioc
+func logSkipped(l *log.Logger, format string, ioc *IOC) {
+	if l != nil {
+		l.Printf("%s: skipping %v IOC %v", format, ioc.DataType, ioc.Value)
+	}
+}
+
+// LoggerSetter is implemented by IOCWriters which report skipped IOCs or
+// exceeded capacities
+type LoggerSetter interface {
+	// SetLogger sets the logger to report to, or none if l is nil
+	SetLogger(l *log.Logger)
+}
+
+// SetWriterLogger sets the logger of iw, if it is a LoggerSetter
+func SetWriterLogger(iw IOCWriter, l *log.Logger) {
+	if ls, ok := iw.(LoggerSetter); ok {
+		ls.SetLogger(l)
+	}
+}
+
+// iocWriterAggregator returns a buffered aggregator for the IOCWriter created
+// by newWriter, to be used by MimeType.Aggregator.
+func iocWriterAggregator(newWriter func(io.Writer) IOCWriter) PageContentAggregator {
+	return newBufferedAggregator(func(w io.Writer) PageContentAggregator {
+		return NewIOCWriterAggregator(w, newWriter)
+	})
+}
+
+// JSONWriter writes IOCs in the format of TIE's JSON results, without
+// request parameters
+type JSONWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{w: w}
+}
+
+func (jw *JSONWriter) WriteIOC(ioc *IOC) error {
+	data, err := json.Marshal(ioc)
+	if err != nil {
+		return err
+	}
+
+	sep := ","
+	if !jw.started {
+		sep = `{"iocs":[`
+		jw.started = true
+	}
+	if _, err := io.WriteString(jw.w, sep); err != nil {
+		return err
+	}
+	_, err = jw.w.Write(data)
+	return err
+}
+
+func (jw *JSONWriter) Close() error {
+	if !jw.started {
+		_, err := io.WriteString(jw.w, "{\"iocs\":[]}\n")
+		return err
+	}
+	_, err := io.WriteString(jw.w, "]}\n")
+	return err
+}
+
+// NDJSONWriter writes one JSON encoded IOC per line
+type NDJSONWriter struct {
+	enc *json.Encoder
+}
+
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	return &NDJSONWriter{enc: json.NewEncoder(w)}
+}
+
+func (nw *NDJSONWriter) WriteIOC(ioc *IOC) error {
+	return nw.enc.Encode(ioc)
+}
+
+func (nw *NDJSONWriter) Close() error {
+	return nil
+}
+
+// CSVColumns are the columns written by CSVWriter
+var CSVColumns = []string{
+	"id", "value", "data_type", "categories", "source_pseudonyms",
+	"min_severity", "max_severity", "min_confidence", "max_confidence",
+	"n_occurrences", "first_seen", "last_seen", "created_at", "updated_at",
+}
+
+// CSVWriter writes IOCs as CSV with a header line naming the CSVColumns.
+// List fields are joined by commas.
+type CSVWriter struct {
+	w       *csv.Writer
+	started bool
+}
+
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (cw *CSVWriter) WriteIOC(ioc *IOC) error {
+	if !cw.started {
+		cw.started = true
+		if err := cw.w.Write(CSVColumns); err != nil {
+			return err
+		}
+	}
+
+	return cw.w.Write([]string{
+		ioc.ID,
+		ioc.Value,
+		ioc.DataType,
+		strings.Join(ioc.Categories, ","),
+		strings.Join(ioc.SourcePseudonyms, ","),
+		strconv.Itoa(ioc.MinSeverity),
+		strconv.Itoa(ioc.MaxSeverity),
+		strconv.Itoa(ioc.MinConfidence),
+		strconv.Itoa(ioc.MaxConfidence),
+		strconv.Itoa(ioc.NOccurrences),
+		csvTime(ioc.FirstSeen),
+		csvTime(ioc.LastSeen),
+		csvTime(ioc.CreatedAt),
+		csvTime(ioc.UpdatedAt),
+	})
+}
+
+func (cw *CSVWriter) Close() error {
+	if !cw.started {
+		cw.started = true
+		if err := cw.w.Write(CSVColumns); err != nil {
+			return err
+		}
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// NewIOCWriter returns an IOCWriter generating output format t locally, for
+// IOCs which do not come from a TIE request, e.g. from a local store. Bloom
+// filters are built by a BloomWriter. TIE's STIX 1.x format can not be
+// generated locally.
+func NewIOCWriter(t MimeType, w io.Writer) (IOCWriter, error) {
+	switch t {
+	case JSON:
+		return NewJSONWriter(w), nil
+	case NDJSON:
+		return NewNDJSONWriter(w), nil
+	case CSV:
+		return NewCSVWriter(w), nil
+	case BLOOMv2:
+		return NewBloomWriter(w), nil
+	}
+
+	if newWriter, ok := iocWriters[t]; ok {
+		return newWriter(w), nil
+	}
+
+	return nil, fmt.Errorf("output format %v can not be generated locally", t)
+}
@@ -0,0 +1,93 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLocalIOCWriters(t *testing.T) {
+	iocs := []IOC{
+		{ID: "1", DataType: "DomainName", Value: "evil.example.com", Categories: []string{"c2server", "botnet"}},
+		{ID: "2", DataType: "IPv4", Value: "192.0.2.1"},
+	}
+
+	write := func(mt MimeType) string {
+		var out bytes.Buffer
+		iw, err := NewIOCWriter(mt, &out)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		for i := range iocs {
+			if err := iw.WriteIOC(&iocs[i]); err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+		if err := iw.Close(); err != nil {
+			t.Fatalf(err.Error())
+		}
+		return out.String()
+	}
+
+	ch, err := GetIOCJSONInChan(strings.NewReader(write(JSON)))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	data, err := IOCChanCollect(ch)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(data.Iocs) != 2 || data.Iocs[1].Value != "192.0.2.1" {
+		t.Errorf("unexpected JSON round trip %+v", data.Iocs)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(write(NDJSON)), "\n"); len(lines) != 2 {
+		t.Errorf("expected 2 NDJSON lines, got %q", lines)
+	}
+
+	lines := strings.Split(strings.TrimSpace(write(CSV)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "id,value,data_type,") ||
+		!strings.HasPrefix(lines[1], `1,evil.example.com,DomainName,"c2server,botnet",`) {
+		t.Errorf("unexpected CSV %q", lines)
+	}
+
+	if _, err := NewIOCWriter(STIX, &bytes.Buffer{}); err == nil {
+		t.Errorf("expected error for STIX 1.x")
+	}
+}
+
+func TestIOCWriterDebugLogging(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"iocs": [{"data_type": "Unknown", "value": "198.51.100.1"}]}`))
+	}))
+	defer srv.Close()
+
+	for _, debug := range []bool{false, true} {
+		var buf bytes.Buffer
+		c := NewClient(
+			WithAPIURL(srv.URL+"/"),
+			WithPageDelay(0),
+			WithDebug(debug),
+			WithLogger(log.New(&buf, "", 0)),
+		)
+		if err := c.WriteIOCs("", "unknown", "", "stix2", ioutil.Discard); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if logged := strings.Contains(buf.String(), "STIX 2.1: skipping Unknown IOC 198.51.100.1"); logged != debug {
+			t.Errorf("debug %v: unexpected log %q", debug, buf.String())
+		}
+	}
+
+	for mt, newWriter := range iocWriters {
+		if _, ok := newWriter(ioutil.Discard).(LoggerSetter); !ok {
+			t.Errorf("%v: writer does not take a logger", mt)
+		}
+	}
+}
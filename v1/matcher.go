@@ -0,0 +1,153 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"strings"
+
+	"github.com/DCSO/bloom"
+)
+
+// Match is an observable matching an IOC
+type Match struct {
+	// Observable is the matching value, for domain suffix matches the
+	// parent domain of the observable which was found
+	Observable string
+	// IOC is the matched IOC. It is nil for matches in a bloom filter,
+	// which do not carry any IOC details.
+	IOC *IOC
+}
+
+// Matcher matches observables against a set of IOCs, using an exact match
+// index per data type on the normalized IOC values. Domain names also match
+// all of their subdomains. Values can additionally be checked against a
+// bloom filter.
+//
+// Matcher is an IOCWriter, so it can be filled from result pages by an
+// IOCWriterAggregator.
+type Matcher struct {
+	// Filter is an optional bloom filter the observables are checked
+	// against
+	Filter *bloom.BloomFilter
+
+	index map[string]map[string]*IOC
+	types []string
+	n     int
+}
+
+func NewMatcher() *Matcher {
+	return &Matcher{
+		index: make(map[string]map[string]*IOC),
+	}
+}
+
+// Add adds ioc to the index
+func (m *Matcher) Add(ioc *IOC) {
+	value := NormalizeIOCValue(ioc)
+	if value == "" {
+		return
+	}
+
+	dataType, _ := CanonicalDataType(ioc.DataType)
+	values, ok := m.index[dataType]
+	if !ok {
+		values = make(map[string]*IOC)
+		m.index[dataType] = values
+		m.types = append(m.types, dataType)
+	}
+
+	if _, ok := values[value]; !ok {
+		m.n++
+	}
+	values[value] = ioc
+}
+
+// AddChan adds all IOCs received from in. It returns the first error
+// received, after draining the channel.
+func (m *Matcher) AddChan(in <-chan IOCResult) (err error) {
+	for result := range in {
+		if result.Error != nil {
+			if err == nil {
+				err = result.Error
+			}
+			continue
+		}
+		m.Add(result.IOC)
+	}
+	return
+}
+
+// Len returns the number of indexed IOCs
+func (m *Matcher) Len() int {
+	return m.n
+}
+
+func (m *Matcher) WriteIOC(ioc *IOC) error {
+	m.Add(ioc)
+	return nil
+}
+
+func (m *Matcher) Close() error {
+	return nil
+}
+
+// parentDomains returns domain and all of its parent domains
+func parentDomains(domain string) []string {
+	domains := []string{domain}
+	for i := strings.IndexByte(domain, '.'); i >= 0; i = strings.IndexByte(domain, '.') {
+		domain = domain[i+1:]
+		if domain == "" {
+			break
+		}
+		domains = append(domains, domain)
+	}
+	return domains
+}
+
+// Match returns the IOCs matching observable. The observable is normalized
+// for every indexed data type before it is looked up.
+func (m *Matcher) Match(observable string) []Match {
+	if strings.TrimSpace(observable) == "" {
+		return nil
+	}
+
+	var matches []Match
+
+	for _, dataType := range m.types {
+		values := m.index[dataType]
+		value := NormalizeIOCValue(&IOC{DataType: dataType, Value: observable})
+
+		if dataType == DataTypeDomainName {
+			for _, domain := range parentDomains(value) {
+				if ioc, ok := values[domain]; ok {
+					matches = append(matches, Match{Observable: domain, IOC: ioc})
+				}
+			}
+			continue
+		}
+
+		if ioc, ok := values[value]; ok {
+			matches = append(matches, Match{Observable: value, IOC: ioc})
+		}
+	}
+
+	if m.Filter != nil {
+		value := strings.TrimSpace(observable)
+		candidates := []string{value}
+		if lower := strings.ToLower(value); lower != value {
+			candidates = append(candidates, lower)
+		}
+		for _, domain := range parentDomains(strings.TrimSuffix(strings.ToLower(value), "."))[1:] {
+			candidates = append(candidates, domain)
+		}
+		for _, candidate := range candidates {
+			if m.Filter.Check([]byte(candidate)) {
+				matches = append(matches, Match{Observable: candidate})
+				break
+			}
+		}
+	}
+
+	return matches
+}
@@ -0,0 +1,66 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DCSO/bloom"
+)
+
+func TestMatcher(t *testing.T) {
+	m := NewMatcher()
+	for _, ioc := range []IOC{
+		{ID: "1", DataType: "DomainName", Value: "Evil.example.com"},
+		{ID: "2", DataType: "IPv4", Value: "192.0.2.1"},
+		{ID: "3", DataType: "ExactHash", Value: "md5:" + strings.Repeat("ab", 16)},
+		{ID: "4", DataType: "DomainName", Value: "evil.example.com."},
+	} {
+		ioc := ioc
+		m.Add(&ioc)
+	}
+	if m.Len() != 3 {
+		t.Errorf("expected 3 indexed IOCs, got %v", m.Len())
+	}
+
+	for observable, expected := range map[string]string{
+		"evil.example.com":         "evil.example.com",
+		"WWW.Evil.Example.com.":    "evil.example.com",
+		"192.0.2.1":                "192.0.2.1",
+		strings.Repeat("AB", 16):   strings.Repeat("ab", 16),
+		"example.com":              "",
+		"notevil.example.com":      "",
+		"192.0.2.10":               "",
+		"  ":                       "",
+		"www.evil.example.com.org": "",
+	} {
+		matches := m.Match(observable)
+		if expected == "" {
+			if len(matches) != 0 {
+				t.Errorf("expected no match for %q, got %+v", observable, matches)
+			}
+			continue
+		}
+		if len(matches) != 1 || matches[0].Observable != expected || matches[0].IOC == nil {
+			t.Errorf("expected match %q for %q, got %+v", expected, observable, matches)
+		}
+	}
+}
+
+func TestMatcherBloom(t *testing.T) {
+	f := bloom.Initialize(10, 0.0001)
+	f.Add([]byte("evil.example.com"))
+
+	m := NewMatcher()
+	m.Filter = &f
+
+	matches := m.Match("www.Evil.example.com")
+	if len(matches) != 1 || matches[0].Observable != "evil.example.com" || matches[0].IOC != nil {
+		t.Errorf("unexpected bloom matches %+v", matches)
+	}
+	if matches := m.Match("good.example.org"); len(matches) != 0 {
+		t.Errorf("unexpected bloom matches %+v", matches)
+	}
+}
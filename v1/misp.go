@@ -0,0 +1,251 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// MISP threat levels
+const (
+	MISPThreatLevelHigh      = "1"
+	MISPThreatLevelMedium    = "2"
+	MISPThreatLevelLow       = "3"
+	MISPThreatLevelUndefined = "4"
+)
+
+// MISPTag is a MISP tag attached to an event or attribute
+type MISPTag struct {
+	Name string `json:"name"`
+}
+
+// MISPAttribute is a MISP event attribute
+type MISPAttribute struct {
+	UUID      string    `json:"uuid"`
+	Type      string    `json:"type"`
+	Category  string    `json:"category"`
+	Value     string    `json:"value"`
+	ToIDS     bool      `json:"to_ids"`
+	Comment   string    `json:"comment,omitempty"`
+	FirstSeen string    `json:"first_seen,omitempty"`
+	LastSeen  string    `json:"last_seen,omitempty"`
+	Tag       []MISPTag `json:"Tag,omitempty"`
+}
+
+// MISPType returns the MISP attribute type and category for the data type
+// of ioc. It returns false for data types without a MISP representation.
+func MISPType(ioc *IOC) (mispType, category string, ok bool) {
+	dataType, _ := CanonicalDataType(ioc.DataType)
+
+	switch dataType {
+	case DataTypeDomainName:
+		return "domain", "Network activity", true
+	case DataTypeURLVerbatim:
+		return "url", "Network activity", true
+	case DataTypeIPv4, DataTypeIPv6:
+		return "ip-dst", "Network activity", true
+	case DataTypeUserAgent:
+		return "user-agent", "Network activity", true
+	case DataTypeASN:
+		return "AS", "Network activity", true
+	case DataTypeEmail:
+		return "email-src", "Payload delivery", true
+	case DataTypeFileName:
+		return "filename", "Payload delivery", true
+	case DataTypeExactHash:
+		switch algorithm, _ := HashAlgorithm(ioc.Value); algorithm {
+		case HashMD5:
+			return "md5", "Payload delivery", true
+		case HashSHA1:
+			return "sha1", "Payload delivery", true
+		case HashSHA256:
+			return "sha256", "Payload delivery", true
+		case HashSHA512:
+			return "sha512", "Payload delivery", true
+		}
+	case DataTypeMutex:
+		return "mutex", "Artifacts dropped", true
+	case DataTypeRegistryKey:
+		return "regkey", "Persistence mechanism", true
+	case DataTypeCVE:
+		return "vulnerability", "External analysis", true
+	}
+
+	return "", "", false
+}
+
+// MISPTags returns the tags of ioc, one per TIE category and one for its
+// maximum severity
+func MISPTags(ioc *IOC) []MISPTag {
+	tags := make([]MISPTag, 0, len(ioc.Categories)+1)
+	for _, category := range ioc.Categories {
+		tags = append(tags, MISPTag{Name: fmt.Sprintf("tie:category=%q", category)})
+	}
+	tags = append(tags, MISPTag{Name: fmt.Sprintf("tie:severity=\"%d\"", ioc.MaxSeverity)})
+	return tags
+}
+
+// NewMISPAttribute converts ioc into a MISP attribute. It returns false for
+// data types without a MISP representation.
+func NewMISPAttribute(ioc *IOC) (*MISPAttribute, bool) {
+	mispType, category, ok := MISPType(ioc)
+	if !ok {
+		return nil, false
+	}
+
+	value := ioc.Value
+	if dataType, _ := CanonicalDataType(ioc.DataType); dataType == DataTypeExactHash {
+		_, value = HashAlgorithm(ioc.Value)
+	} else if mispType == "AS" {
+		value = strings.TrimPrefix(strings.ToUpper(value), "AS")
+	}
+
+	attr := &MISPAttribute{
+		UUID:     iocUUID(ioc),
+		Type:     mispType,
+		Category: category,
+		Value:    value,
+		ToIDS:    mispType != "vulnerability",
+		Tag:      MISPTags(ioc),
+	}
+	if ioc.ID != "" {
+		attr.Comment = "DCSO TIE " + ioc.ID
+	}
+	if ioc.FirstSeen != nil {
+		attr.FirstSeen = ioc.FirstSeen.UTC().Format(time.RFC3339)
+	}
+	if ioc.LastSeen != nil {
+		attr.LastSeen = ioc.LastSeen.UTC().Format(time.RFC3339)
+	}
+
+	return attr, true
+}
+
+// MISPWriter writes IOCs as the attributes of a single MISP event in the
+// JSON format accepted by MISP's event import. The attributes are streamed;
+// the event's threat level is derived from the highest IOC severity and
+// written on Close.
+type MISPWriter struct {
+	// Info is the event title
+	Info string
+	// Date is the event date
+	Date time.Time
+	// Tags are attached to the event
+	Tags []string
+	// Skipped counts the IOCs which could not be converted
+	Skipped int
+	// Logger, if set, is told about each skipped IOC
+	Logger *log.Logger
+
+	w           io.Writer
+	started     bool
+	maxSeverity int
+}
+
+func NewMISPWriter(w io.Writer) *MISPWriter {
+	return &MISPWriter{
+		Info:        "DCSO TIE IOC export",
+		Date:        time.Now(),
+		w:           w,
+		maxSeverity: -1,
+	}
+}
+
+// SetLogger implements LoggerSetter
+func (mw *MISPWriter) SetLogger(l *log.Logger) {
+	mw.Logger = l
+}
+
+func (mw *MISPWriter) WriteIOC(ioc *IOC) error {
+	attr, ok := NewMISPAttribute(ioc)
+	if !ok {
+		logSkipped(mw.Logger, "MISP", ioc)
+		mw.Skipped++
+		return nil
+	}
+
+	data, err := json.Marshal(attr)
+	if err != nil {
+		return err
+	}
+
+	sep := ","
+	if !mw.started {
+		sep = `{"Event":{"Attribute":[`
+		mw.started = true
+	}
+	if _, err := io.WriteString(mw.w, sep); err != nil {
+		return err
+	}
+	if _, err := mw.w.Write(data); err != nil {
+		return err
+	}
+
+	if ioc.MaxSeverity > mw.maxSeverity {
+		mw.maxSeverity = ioc.MaxSeverity
+	}
+
+	return nil
+}
+
+// threatLevel maps the highest severity of the event's IOCs to a MISP
+// threat level
+func (mw *MISPWriter) threatLevel() string {
+	switch {
+	case mw.maxSeverity >= 4:
+		return MISPThreatLevelHigh
+	case mw.maxSeverity == 3:
+		return MISPThreatLevelMedium
+	case mw.maxSeverity >= 1:
+		return MISPThreatLevelLow
+	default:
+		return MISPThreatLevelUndefined
+	}
+}
+
+func (mw *MISPWriter) Close() error {
+	if !mw.started {
+		if _, err := io.WriteString(mw.w, `{"Event":{"Attribute":[`); err != nil {
+			return err
+		}
+	}
+
+	id, err := uuid4()
+	if err != nil {
+		return err
+	}
+
+	event := struct {
+		UUID          string    `json:"uuid"`
+		Info          string    `json:"info"`
+		Date          string    `json:"date"`
+		ThreatLevelID string    `json:"threat_level_id"`
+		Analysis      string    `json:"analysis"`
+		Published     bool      `json:"published"`
+		Tag           []MISPTag `json:"Tag,omitempty"`
+	}{
+		UUID:          id,
+		Info:          mw.Info,
+		Date:          mw.Date.Format("2006-01-02"),
+		ThreatLevelID: mw.threatLevel(),
+		Analysis:      "2",
+	}
+	for _, tag := range mw.Tags {
+		event.Tag = append(event.Tag, MISPTag{Name: tag})
+	}
+
+	data, err := json.Marshal(&event)
+	if err != nil {
+		return err
+	}
+
+	// Append the event fields to the already written attribute list
+	_, err = fmt.Fprintf(mw.w, "],%s}}\n", data[1:len(data)-1])
+	return err
+}
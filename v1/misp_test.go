@@ -0,0 +1,87 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMISPWriter(t *testing.T) {
+	lastSeen := time.Date(2018, 3, 2, 9, 0, 0, 0, time.UTC)
+	iocs := []IOC{
+		{
+			ID:          "ioc-1",
+			DataType:    "DomainName",
+			Value:       "evil.example.com",
+			Categories:  []string{"c2server", "botnet"},
+			MaxSeverity: 3,
+			LastSeen:    &lastSeen,
+		},
+		{ID: "ioc-2", DataType: "ExactHash", Value: "SHA1:" + strings.Repeat("A", 40), MaxSeverity: 4},
+		{ID: "ioc-3", DataType: "SomethingElse", Value: "x"},
+	}
+
+	var out bytes.Buffer
+	mw := NewMISPWriter(&out)
+	mw.Info = "test event"
+	mw.Tags = []string{"tlp:amber"}
+	for i := range iocs {
+		if err := mw.WriteIOC(&iocs[i]); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if mw.Skipped != 1 {
+		t.Errorf("expected 1 skipped IOC, got %v", mw.Skipped)
+	}
+
+	var doc struct {
+		Event struct {
+			Info          string          `json:"info"`
+			ThreatLevelID string          `json:"threat_level_id"`
+			Attribute     []MISPAttribute `json:"Attribute"`
+			Tag           []MISPTag       `json:"Tag"`
+		}
+	}
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid MISP event %q: %v", out.String(), err)
+	}
+
+	event := doc.Event
+	if event.Info != "test event" || event.ThreatLevelID != MISPThreatLevelHigh {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if len(event.Tag) != 1 || event.Tag[0].Name != "tlp:amber" {
+		t.Errorf("unexpected event tags %v", event.Tag)
+	}
+	if len(event.Attribute) != 2 {
+		t.Fatalf("expected 2 attributes, got %v", len(event.Attribute))
+	}
+
+	domain := event.Attribute[0]
+	if domain.Type != "domain" || domain.Value != "evil.example.com" || !domain.ToIDS {
+		t.Errorf("unexpected domain attribute %+v", domain)
+	}
+	if domain.LastSeen != "2018-03-02T09:00:00Z" || domain.FirstSeen != "" {
+		t.Errorf("unexpected seen timestamps %v %v", domain.FirstSeen, domain.LastSeen)
+	}
+	tags := []string{}
+	for _, tag := range domain.Tag {
+		tags = append(tags, tag.Name)
+	}
+	if strings.Join(tags, " ") != `tie:category="c2server" tie:category="botnet" tie:severity="3"` {
+		t.Errorf("unexpected attribute tags %v", tags)
+	}
+
+	hash := event.Attribute[1]
+	if hash.Type != "sha1" || hash.Value != strings.Repeat("a", 40) {
+		t.Errorf("unexpected hash attribute %+v", hash)
+	}
+}
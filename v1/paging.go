@@ -4,6 +4,7 @@ package gotie
 // Copyright (c) 2017-2018, DCSO GmbH
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -13,6 +14,9 @@ import (
 	"github.com/DCSO/bloom"
 )
 
+// PageContentAggregator combines the pages of a paginated TIE response into
+// a single result. Aggregators either collect all pages and write the result
+// on Finish, or stream each page to their destination when it is added.
 type PageContentAggregator interface {
 	AddPage(io.Reader) error
 	Finish(io.Writer) error
@@ -79,7 +83,178 @@ func (pa *JSONPageAggregator) Reset() {
 	*pa = JSONPageAggregator{}
 }
 
+// bufferedAggregator keeps the output of a streaming aggregator in memory
+// until Finish is called
+type bufferedAggregator struct {
+	buf bytes.Buffer
+	agg PageContentAggregator
+}
+
+func newBufferedAggregator(newAgg func(io.Writer) PageContentAggregator) *bufferedAggregator {
+	ba := &bufferedAggregator{}
+	ba.agg = newAgg(&ba.buf)
+	return ba
+}
+
+func (ba *bufferedAggregator) AddPage(reader io.Reader) error {
+	return ba.agg.AddPage(reader)
+}
+
+func (ba *bufferedAggregator) Finish(writer io.Writer) error {
+	if err := ba.agg.Finish(&ba.buf); err != nil {
+		return err
+	}
+	_, err := ba.buf.WriteTo(writer)
+	return err
+}
+
+func (ba *bufferedAggregator) Reset() {
+	ba.buf.Reset()
+	ba.agg.Reset()
+}
+
+// CSVStreamAggregator writes CSV pages to its destination as they arrive.
+// The header line is written once, the repeated headers of subsequent
+// pages are dropped. Finish has nothing left to write.
+type CSVStreamAggregator struct {
+	w          io.Writer
+	headerDone bool
+}
+
+func NewCSVStreamAggregator(w io.Writer) *CSVStreamAggregator {
+	return &CSVStreamAggregator{w: w}
+}
+
+func (pa *CSVStreamAggregator) AddPage(reader io.Reader) error {
+	if pa.headerDone {
+		r := bufio.NewReader(reader)
+		if _, err := r.ReadString('\n'); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		reader = r
+	}
+
+	_, err := io.Copy(pa.w, reader)
+	pa.headerDone = true
+	return err
+}
+
+func (pa *CSVStreamAggregator) Finish(writer io.Writer) error {
+	return nil
+}
+
+func (pa *CSVStreamAggregator) Reset() {
+	pa.headerDone = false
+}
+
+// JSONStreamAggregator writes the IOCs of JSON pages to its destination as
+// they arrive, using the same {"params": ..., "iocs": [...]} layout as
+// JSONPageAggregator. The params are taken from the first page; Finish
+// closes the IOC array.
+type JSONStreamAggregator struct {
+	w       io.Writer
+	started bool
+}
+
+func NewJSONStreamAggregator(w io.Writer) *JSONStreamAggregator {
+	return &JSONStreamAggregator{w: w}
+}
+
+func (pa *JSONStreamAggregator) begin(params IOCParams) error {
+	params.Offset = 0
+
+	p, err := json.Marshal(&params)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(pa.w, `{"params":%s,"iocs":[`, p)
+	return err
+}
+
+func (pa *JSONStreamAggregator) AddPage(reader io.Reader) error {
+	var tlr JSONTopLevelResponse
+
+	if err := json.NewDecoder(reader).Decode(&tlr); err != nil {
+		return err
+	}
+
+	for i := range tlr.IOCs {
+		if !pa.started {
+			if err := pa.begin(tlr.Params); err != nil {
+				return err
+			}
+		} else if _, err := io.WriteString(pa.w, ","); err != nil {
+			return err
+		}
+		pa.started = true
+
+		ioc, err := json.Marshal(&tlr.IOCs[i])
+		if err != nil {
+			return err
+		}
+		if _, err := pa.w.Write(ioc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pa *JSONStreamAggregator) Finish(writer io.Writer) error {
+	if !pa.started {
+		if err := pa.begin(IOCParams{}); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(pa.w, "]}\n")
+	return err
+}
+
+func (pa *JSONStreamAggregator) Reset() {
+	pa.started = false
+}
+
+// NDJSONAggregator writes the IOCs of JSON pages to its destination as
+// newline delimited JSON, one IOC per line.
+type NDJSONAggregator struct {
+	enc *json.Encoder
+}
+
+func NewNDJSONAggregator(w io.Writer) *NDJSONAggregator {
+	return &NDJSONAggregator{enc: json.NewEncoder(w)}
+}
+
+func (pa *NDJSONAggregator) AddPage(reader io.Reader) error {
+	var tlr JSONTopLevelResponse
+
+	if err := json.NewDecoder(reader).Decode(&tlr); err != nil {
+		return err
+	}
+
+	for i := range tlr.IOCs {
+		if err := pa.enc.Encode(&tlr.IOCs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pa *NDJSONAggregator) Finish(writer io.Writer) error {
+	return nil
+}
+
+func (pa *NDJSONAggregator) Reset() {}
+
 type BloomPageAggregator struct {
+	// Logger, if set, receives debug messages
+	Logger *log.Logger
+
 	f *bloom.BloomFilter
 }
 
@@ -103,8 +278,8 @@ func (ba *BloomPageAggregator) AddPage(reader io.Reader) (err error) {
 
 func (ba *BloomPageAggregator) Finish(writer io.Writer) error {
 	if ba.f == nil {
-		if Debug {
-			log.Printf("Writing empty bloom filter")
+		if ba.Logger != nil {
+			ba.Logger.Printf("Writing empty bloom filter")
 		}
 		empty := bloom.Initialize(0, 0.01)
 		ba.f = &empty
@@ -116,22 +291,3 @@ func (ba *BloomPageAggregator) Finish(writer io.Writer) error {
 func (ba *BloomPageAggregator) Reset() {
 	ba.f = nil
 }
-
-// to be implemented
-/*
-type STIXPageAggregator struct {
-	buf bytes.Buffer
-}
-
-func (pa *STIXPageAggregator) AddPage(reader io.Reader) error {
-	return nil
-}
-
-func (pa *STIXPageAggregator) Finish(writer io.Writer) error {
-	return nil
-}
-
-func (pa *STIXPageAggregator) Reset() {
-	pa.buf.Reset()
-}
-*/
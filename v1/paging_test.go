@@ -1,18 +1,24 @@
-package gotie
+package gotie_test
 
 // DCSO gotie API bindings
 // Copyright (c) 2018, DCSO GmbH
 
 import (
 	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"testing"
 
 	"github.com/DCSO/bloom"
+
+	. "github.com/DCSO/gotie/v1"
 )
 
 func TestBloomPageAggregator(t *testing.T) {
+	defer useServer()()
+
 	iocsBuf, err := ioutil.TempFile("", "gotie-iocs")
 	if err != nil {
 		t.Fatalf(err.Error())
@@ -87,3 +93,92 @@ func TestBloomPageAggregator(t *testing.T) {
 		t.Errorf("expected no negatives but got %v", n)
 	}
 }
+
+func TestCSVStreamAggregator(t *testing.T) {
+	var out bytes.Buffer
+	agg := NewCSVStreamAggregator(&out)
+
+	for _, page := range []string{
+		"value,data_type\na.example.com,DomainName\n",
+		"value,data_type\nb.example.com,DomainName\n",
+		"value,data_type\n",
+	} {
+		if err := agg.AddPage(bytes.NewBufferString(page)); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := agg.Finish(&out); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expected := "value,data_type\na.example.com,DomainName\nb.example.com,DomainName\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestJSONStreamAggregators(t *testing.T) {
+	pages := []string{
+		`{"params": {"limit": 1, "offset": 0}, "iocs": [{"id": "1", "value": "a.example.com"}], "has_more": true}`,
+		`{"params": {"limit": 1, "offset": 1}, "iocs": [{"id": "2", "value": "b.example.com"}], "has_more": false}`,
+	}
+
+	var jsonOut, ndjsonOut bytes.Buffer
+	jsonAgg := NewJSONStreamAggregator(&jsonOut)
+	ndjsonAgg := NewNDJSONAggregator(&ndjsonOut)
+
+	for _, page := range pages {
+		if err := jsonAgg.AddPage(bytes.NewBufferString(page)); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if err := ndjsonAgg.AddPage(bytes.NewBufferString(page)); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	// JSON output is written before Finish and completed by it
+	if jsonOut.Len() == 0 {
+		t.Errorf("JSON output was not streamed")
+	}
+	if err := jsonAgg.Finish(&jsonOut); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := ndjsonAgg.Finish(&ndjsonOut); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	jsonchan, err := GetIOCJSONInChan(&jsonOut)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	res, err := IOCChanCollect(jsonchan)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(res.Iocs) != 2 || res.Iocs[0].ID != "1" || res.Iocs[1].ID != "2" {
+		t.Errorf("unexpected JSON stream result %v", res.Iocs)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(ndjsonOut.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 NDJSON lines, got %v", len(lines))
+	}
+	for i, line := range lines {
+		var ioc IOC
+		if err := json.Unmarshal(line, &ioc); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if ioc.ID != strconv.Itoa(i+1) {
+			t.Errorf("unexpected NDJSON IOC %v", ioc)
+		}
+	}
+
+	var empty bytes.Buffer
+	emptyAgg := NewJSONStreamAggregator(&empty)
+	if err := emptyAgg.Finish(&empty); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := json.Unmarshal(empty.Bytes(), &IOCQueryStruct{}); err != nil {
+		t.Errorf("empty JSON stream is invalid: %v", err)
+	}
+}
@@ -0,0 +1,445 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sighting is an observation of an IOC value at the time Seen
+type Sighting struct {
+	DataType string
+	Value    string
+	Seen     time.Time
+}
+
+// newPingbackRequest returns the submission request for s
+func (c *Client) newPingbackRequest(ctx context.Context, s Sighting) (*http.Request, error) {
+	form := url.Values{}
+	form.Add("data_type", s.DataType)
+	form.Add("value", s.Value)
+	form.Add("seen", s.Seen.UTC().Format(time.RFC3339))
+
+	req, err := http.NewRequest("POST", c.pingbackURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Authorization", "Bearer "+c.pingbackToken)
+
+	c.debugf("pingback: POST %v %v", c.pingbackURL, form.Encode())
+
+	return req, nil
+}
+
+// SubmitSighting reports s to the pingback API. A zero Seen time is set to
+// the current time. Responses other than 2xx are returned as *APIError.
+func (c *Client) SubmitSighting(s Sighting) (*PingbackResponse, error) {
+	return c.SubmitSightingContext(context.Background(), s)
+}
+
+// SubmitSightingContext is like SubmitSighting but aborts the submission
+// when ctx is cancelled.
+func (c *Client) SubmitSightingContext(ctx context.Context, s Sighting) (*PingbackResponse, error) {
+	if s.Seen.IsZero() {
+		s.Seen = time.Now()
+	}
+
+	req, err := c.newPingbackRequest(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newAPIError(resp)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return nil, err
+	}
+	c.debugf("pingback: %v %s", resp.Status, body)
+
+	var msg apiMessage
+	if !strings.Contains(resp.Header.Get("Content-Type"), string(JSON)) || json.Unmarshal(body, &msg) != nil {
+		msg = apiMessage{Message: string(bytes.TrimSpace(body))}
+	}
+
+	return &PingbackResponse{StatusCode: resp.StatusCode, Message: msg.Message}, nil
+}
+
+// PingbackResult is the outcome of a sighting passed to a PingbackBatcher
+type PingbackResult struct {
+	Sighting Sighting
+	// Duplicate is set for sightings which were not submitted because the
+	// same value was seen within the deduplication window
+	Duplicate bool
+	// Response is the response to a successful submission
+	Response *PingbackResponse
+	// Error is the error of the last submission attempt
+	Error error
+}
+
+// ErrBatcherClosed is returned when adding sightings to a closed
+// PingbackBatcher
+var ErrBatcherClosed = errors.New("pingback batcher closed")
+
+// PingbackOption configures a PingbackBatcher
+type PingbackOption func(*PingbackBatcher)
+
+// WithBatchSize sets the maximum number of sightings submitted at once
+func WithBatchSize(n int) PingbackOption {
+	return func(b *PingbackBatcher) {
+		b.batchSize = n
+	}
+}
+
+// WithFlushInterval sets the maximum time a sighting is queued before its
+// batch is submitted
+func WithFlushInterval(d time.Duration) PingbackOption {
+	return func(b *PingbackBatcher) {
+		b.flushInterval = d
+	}
+}
+
+// WithConcurrency sets the number of concurrent submissions of a batch
+func WithConcurrency(n int) PingbackOption {
+	return func(b *PingbackBatcher) {
+		b.concurrency = n
+	}
+}
+
+// WithPendingBatches sets the number of batches submitted at the same time
+func WithPendingBatches(n int) PingbackOption {
+	return func(b *PingbackBatcher) {
+		b.pendingBatches = n
+	}
+}
+
+// WithSubmitRetries sets the number of attempts per sighting and the wait
+// before the first retry, which doubles with every further attempt
+func WithSubmitRetries(attempts int, wait time.Duration) PingbackOption {
+	return WithSubmitRetryPolicy(&BackoffPolicy{MaxAttempts: attempts, Wait: wait})
+}
+
+// WithSubmitRetryPolicy sets the policy deciding which failed submissions
+// are repeated. The default is the client's retry policy.
+func WithSubmitRetryPolicy(p RetryPolicy) PingbackOption {
+	return func(b *PingbackBatcher) {
+		b.retryPolicy = p
+	}
+}
+
+// WithDedupWindow drops sightings of a value seen less than d before or
+// after an already submitted sighting of the same value. Zero disables
+// deduplication.
+func WithDedupWindow(d time.Duration) PingbackOption {
+	return func(b *PingbackBatcher) {
+		b.dedupWindow = d
+	}
+}
+
+// WithResultFunc sets a function called with the result of every sighting.
+// It is called from several goroutines concurrently.
+func WithResultFunc(f func(PingbackResult)) PingbackOption {
+	return func(b *PingbackBatcher) {
+		b.resultFunc = f
+	}
+}
+
+// WithResultChan sends the result of every sighting to ch, which must be
+// read until the batcher is closed.
+func WithResultChan(ch chan<- PingbackResult) PingbackOption {
+	return func(b *PingbackBatcher) {
+		b.resultChan = ch
+	}
+}
+
+// PingbackBatcher queues sightings and submits them in the background.
+// Queued sightings are submitted in batches once BatchSize sightings are
+// queued or the flush interval has passed, with a bounded number of
+// concurrent requests per batch. Several batches are submitted at the same
+// time, so a batch waiting for retries does not hold up the following
+// ones; Add only blocks once all pending batches are being submitted and
+// the queue is full. Failed submissions are retried with back off on
+// network errors, rate limiting and server errors.
+type PingbackBatcher struct {
+	client         *Client
+	batchSize      int
+	flushInterval  time.Duration
+	concurrency    int
+	pendingBatches int
+	retryPolicy    RetryPolicy
+	dedupWindow    time.Duration
+	resultFunc     func(PingbackResult)
+	resultChan     chan<- PingbackResult
+
+	ctx    context.Context
+	queue  chan Sighting
+	failed chan Sighting
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+	// seen is only used by the run goroutine
+	seen map[string]time.Time
+}
+
+// NewPingbackBatcher returns a started PingbackBatcher submitting with the
+// client's pingback token
+func (c *Client) NewPingbackBatcher(opts ...PingbackOption) *PingbackBatcher {
+	return c.NewPingbackBatcherContext(context.Background(), opts...)
+}
+
+// NewPingbackBatcherContext is like NewPingbackBatcher, but submissions are
+// aborted once ctx is cancelled.
+func (c *Client) NewPingbackBatcherContext(ctx context.Context, opts ...PingbackOption) *PingbackBatcher {
+	b := &PingbackBatcher{
+		client:         c,
+		batchSize:      100,
+		flushInterval:  time.Second,
+		concurrency:    4,
+		pendingBatches: 4,
+		retryPolicy:    c.retryPolicy,
+		dedupWindow:    5 * time.Minute,
+		ctx:            ctx,
+		failed:         make(chan Sighting),
+		done:           make(chan struct{}),
+		seen:           make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.batchSize < 1 {
+		b.batchSize = 1
+	}
+	if b.concurrency < 1 {
+		b.concurrency = 1
+	}
+	if b.pendingBatches < 1 {
+		b.pendingBatches = 1
+	}
+	b.queue = make(chan Sighting, b.batchSize)
+
+	go b.run()
+
+	return b
+}
+
+// Add queues s for submission. A zero Seen time is set to the current
+// time. Add blocks while the queue is full.
+func (b *PingbackBatcher) Add(s Sighting) error {
+	if s.Seen.IsZero() {
+		s.Seen = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrBatcherClosed
+	}
+	b.queue <- s
+	return nil
+}
+
+// Close submits the queued sightings and waits until all results are
+// reported
+func (b *PingbackBatcher) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBatcherClosed
+	}
+	b.closed = true
+	close(b.queue)
+	b.mu.Unlock()
+
+	<-b.done
+	return nil
+}
+
+func (b *PingbackBatcher) report(r PingbackResult) {
+	if b.resultFunc != nil {
+		b.resultFunc(r)
+	}
+	if b.resultChan != nil {
+		b.resultChan <- r
+	}
+}
+
+// dedupKey identifies the value of s for deduplication
+func dedupKey(s Sighting) string {
+	return strings.ToLower(s.DataType) + "\x00" + s.Value
+}
+
+// duplicate reports whether s was seen within the deduplication window of
+// a sighting of the same value, and records it otherwise
+func (b *PingbackBatcher) duplicate(s Sighting) bool {
+	if b.dedupWindow <= 0 {
+		return false
+	}
+
+	key := dedupKey(s)
+	if last, ok := b.seen[key]; ok {
+		if d := s.Seen.Sub(last); d < b.dedupWindow && d > -b.dedupWindow {
+			return true
+		}
+	}
+	b.seen[key] = s.Seen
+	return false
+}
+
+// forget removes the record of s, whose submission failed, so the value can
+// be submitted again
+func (b *PingbackBatcher) forget(s Sighting) {
+	key := dedupKey(s)
+	if last, ok := b.seen[key]; ok && last.Equal(s.Seen) {
+		delete(b.seen, key)
+	}
+}
+
+// expire forgets sightings which can no longer cause duplicates
+func (b *PingbackBatcher) expire() {
+	if b.dedupWindow <= 0 {
+		return
+	}
+	limit := time.Now().Add(-2 * b.dedupWindow)
+	for key, seen := range b.seen {
+		if seen.Before(limit) {
+			delete(b.seen, key)
+		}
+	}
+}
+
+// run deduplicates the queued sightings and dispatches their batches. The
+// deduplication state is only used here: failed sightings are passed back
+// on b.failed to be forgotten.
+func (b *PingbackBatcher) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	pending := make(chan struct{}, b.pendingBatches)
+	dispatch := func(batch []Sighting) {
+		for len(batch) > 0 {
+			select {
+			case pending <- struct{}{}:
+				go func() {
+					defer func() { <-pending }()
+					b.submit(batch)
+				}()
+				return
+			case s := <-b.failed:
+				b.forget(s)
+			}
+		}
+	}
+
+	batch := make([]Sighting, 0, b.batchSize)
+	for {
+		select {
+		case s, ok := <-b.queue:
+			if !ok {
+				dispatch(batch)
+				// All batches are done once all slots are taken
+				for i := 0; i < cap(pending); {
+					select {
+					case pending <- struct{}{}:
+						i++
+					case s := <-b.failed:
+						b.forget(s)
+					}
+				}
+				return
+			}
+			if b.duplicate(s) {
+				b.report(PingbackResult{Sighting: s, Duplicate: true})
+				continue
+			}
+			batch = append(batch, s)
+			if len(batch) >= b.batchSize {
+				dispatch(batch)
+				batch = make([]Sighting, 0, b.batchSize)
+			}
+		case s := <-b.failed:
+			b.forget(s)
+		case <-ticker.C:
+			if len(batch) > 0 {
+				dispatch(batch)
+				batch = make([]Sighting, 0, b.batchSize)
+			}
+			b.expire()
+		}
+	}
+}
+
+// submit submits batch with bounded concurrency and waits for the results.
+// Failed sightings are passed back to run before their result is reported,
+// so they are not counted as seen for deduplication.
+func (b *PingbackBatcher) submit(batch []Sighting) {
+	b.client.debugf("pingback: submitting %d sightings", len(batch))
+
+	work := make(chan Sighting)
+	var wg sync.WaitGroup
+	for i := 0; i < b.concurrency && i < len(batch); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range work {
+				resp, err := b.submitWithRetry(s)
+				if err != nil {
+					b.failed <- s
+				}
+				b.report(PingbackResult{Sighting: s, Response: resp, Error: err})
+			}
+		}()
+	}
+	for _, s := range batch {
+		work <- s
+	}
+	close(work)
+	wg.Wait()
+}
+
+// submitWithRetry submits s, retrying as the retry policy allows
+func (b *PingbackBatcher) submitWithRetry(s Sighting) (resp *PingbackResponse, err error) {
+	for attempt := 1; ; attempt++ {
+		resp, err = b.client.SubmitSightingContext(b.ctx, s)
+		if err == nil || b.ctx.Err() != nil {
+			return
+		}
+
+		wait, retry := b.retryPolicy.Retry(attempt, err)
+		if !retry {
+			return
+		}
+		b.client.debugf("pingback of %v failed (%v): retrying in %v...", s.Value, err, wait)
+		if serr := sleep(b.ctx, wait); serr != nil {
+			return nil, serr
+		}
+	}
+}
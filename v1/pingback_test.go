@@ -0,0 +1,244 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPingbackBatcher(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]string)
+	attempts := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+		value := r.FormValue("value")
+
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[value]++
+		switch {
+		case value == "flaky.example.com" && attempts[value] == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case value == "bad.example.com":
+			http.Error(w, `{"message":"invalid value"}`, http.StatusBadRequest)
+			return
+		}
+		seen[value] = r.FormValue("seen")
+	}))
+	defer srv.Close()
+
+	client := NewClient(WithPingbackURL(srv.URL), WithPingbackToken("secret"))
+
+	var results []PingbackResult
+	b := client.NewPingbackBatcher(
+		WithBatchSize(2),
+		WithConcurrency(2),
+		WithSubmitRetries(3, time.Millisecond),
+		WithResultFunc(func(r PingbackResult) {
+			mu.Lock()
+			results = append(results, r)
+			mu.Unlock()
+		}),
+	)
+
+	observed := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, s := range []Sighting{
+		{DataType: "DomainName", Value: "a.example.com", Seen: observed},
+		{DataType: "DomainName", Value: "a.example.com", Seen: observed.Add(time.Minute)},
+		{DataType: "DomainName", Value: "a.example.com", Seen: observed.Add(time.Hour)},
+		{DataType: "DomainName", Value: "flaky.example.com", Seen: observed},
+		{DataType: "DomainName", Value: "bad.example.com", Seen: observed},
+	} {
+		if err := b.Add(s); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := b.Add(Sighting{DataType: "DomainName", Value: "late.example.com"}); err != ErrBatcherClosed {
+		t.Fatalf("adding to a closed batcher returned %v", err)
+	}
+
+	if len(results) != 5 {
+		t.Fatalf("expected 5 results, got %d", len(results))
+	}
+	var duplicates, failed int
+	for _, r := range results {
+		if r.Duplicate {
+			duplicates++
+		}
+		if r.Error != nil {
+			failed++
+			if r.Sighting.Value != "bad.example.com" {
+				t.Errorf("unexpected error for %v: %v", r.Sighting.Value, r.Error)
+			}
+		}
+	}
+	if duplicates != 1 || failed != 1 {
+		t.Fatalf("expected 1 duplicate and 1 failure, got %d and %d", duplicates, failed)
+	}
+
+	if attempts["a.example.com"] != 2 {
+		t.Fatalf("expected 2 submissions of a.example.com, got %d", attempts["a.example.com"])
+	}
+	if attempts["flaky.example.com"] != 2 || seen["flaky.example.com"] == "" {
+		t.Fatalf("server error was not retried")
+	}
+	if attempts["bad.example.com"] != 1 {
+		t.Fatalf("client error was retried %d times", attempts["bad.example.com"]-1)
+	}
+	if seen["flaky.example.com"] != "2018-03-01T10:00:00Z" {
+		t.Fatalf("unexpected seen time %q", seen["flaky.example.com"])
+	}
+}
+
+func TestPingbackBatcherFlushInterval(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	results := make(chan PingbackResult, 1)
+	b := NewClient(WithPingbackURL(srv.URL)).NewPingbackBatcher(
+		WithFlushInterval(10*time.Millisecond),
+		WithResultChan(results),
+	)
+	defer b.Close()
+
+	if err := b.Add(Sighting{DataType: "IPv4", Value: "192.0.2.1"}); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	select {
+	case r := <-results:
+		if r.Error != nil {
+			t.Fatalf(r.Error.Error())
+		}
+		if r.Sighting.Seen.IsZero() {
+			t.Fatalf("seen time was not set")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("sighting was not submitted after the flush interval")
+	}
+}
+
+func TestPingbackBatcherResubmitFailed(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if requests++; requests == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	results := make(chan PingbackResult, 2)
+	b := NewClient(WithPingbackURL(srv.URL)).NewPingbackBatcher(
+		WithBatchSize(1),
+		WithSubmitRetries(1, time.Millisecond),
+		WithResultChan(results),
+	)
+
+	seen := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := b.Add(Sighting{DataType: "IPv4", Value: "192.0.2.1", Seen: seen}); err != nil {
+			t.Fatalf(err.Error())
+		}
+		r := <-results
+		if (r.Error != nil) != (i == 0) || r.Duplicate {
+			t.Fatalf("attempt %d: unexpected result %+v", i, r)
+		}
+	}
+	b.Close()
+}
+
+func TestPingbackBatcherPending(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+
+	results := make(chan PingbackResult, 3)
+	b := NewClient(WithPingbackURL(srv.URL)).NewPingbackBatcher(
+		WithBatchSize(1),
+		WithPendingBatches(2),
+		WithResultChan(results),
+	)
+
+	// Two batches are pending, the third is dispatched next and the fourth
+	// waits in the queue
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		for i := 1; i <= 4; i++ {
+			b.Add(Sighting{DataType: "IPv4", Value: fmt.Sprintf("192.0.2.%d", i)})
+		}
+	}()
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Add blocked while batches were pending")
+	}
+
+	close(release)
+	go b.Close()
+	for i := 0; i < 4; i++ {
+		if r := <-results; r.Error != nil {
+			t.Fatalf(r.Error.Error())
+		}
+	}
+}
+
+func TestSubmitSighting(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("data_type") != "DomainName" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message":"invalid data type","errors":["data_type"]}`))
+			return
+		}
+		w.Write([]byte(`{"message":"ok"}`))
+	}))
+	defer srv.Close()
+	client := NewClient(WithPingbackURL(srv.URL))
+
+	resp, err := client.SubmitSighting(Sighting{DataType: "DomainName", Value: "example.com"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if resp.StatusCode != http.StatusOK || resp.Message != "ok" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	err = client.PingBackCall("Foo", "example.com")
+	perr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if perr.StatusCode != http.StatusBadRequest || perr.Message != "invalid data type" {
+		t.Fatalf("unexpected error %+v", perr)
+	}
+	if !IsInvalidRequest(err) || perr.URL != srv.URL {
+		t.Fatalf("unexpected error %+v", perr)
+	}
+	if perr.Error() != "TIE returned 400 Bad Request for "+srv.URL+": invalid data type [data_type]" {
+		t.Fatalf("unexpected error message %q", perr.Error())
+	}
+	if _, ok := err.(*PingbackError); !ok {
+		t.Fatalf("expected the error to remain a PingbackError")
+	}
+}
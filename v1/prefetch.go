@@ -0,0 +1,196 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"sync"
+
+	link "github.com/tent/http-link-go"
+)
+
+// prefetchPage is a page requested by the parallel fetcher
+type prefetchPage struct {
+	offset int
+	buf    bytes.Buffer
+	next   *link.Link
+	err    error
+	done   chan struct{}
+}
+
+// pageURL returns u with the offset parameter set
+func pageURL(u *url.URL, offset int) string {
+	p := *u
+	values := p.Query()
+	values.Set("offset", strconv.Itoa(offset))
+	p.RawQuery = values.Encode()
+	return p.String()
+}
+
+// pageOffset returns the offset parameter of the page address s
+func pageOffset(s string) (int, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return 0, err
+	}
+	v := u.Query().Get("offset")
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// doRequestParallel is doRequest fetching c.parallelPages pages at once.
+// The first page is fetched on its own. The page size is taken from the
+// offset of its next link rather than the requested limit, as TIE may
+// return fewer IOCs per page. The following pages are then requested by
+// offset with bounded concurrency until a page without next link is
+// reached. Pages requested beyond it are discarded. If a next link does
+// not point to the expected offset, the remaining pages are fetched one
+// after the other.
+func (c *Client) doRequestParallel(ctx context.Context, first string, t MimeType, f func(io.Reader) error) error {
+	var buf bytes.Buffer
+	c.debugf("doRequest: GET %v", first)
+	next, err := c.doIteration(ctx, first, t, &buf)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	} else if err != nil {
+		return err
+	}
+	if err := f(&buf); ctx.Err() != nil {
+		return ctx.Err()
+	} else if err != nil {
+		return fmt.Errorf("f: %v", err)
+	}
+	if next == nil {
+		return nil
+	}
+
+	u, err := url.Parse(first)
+	if err != nil {
+		return err
+	}
+	offset, err := pageOffset(first)
+	if err != nil {
+		return err
+	}
+	nextOffset, err := pageOffset(next.URI)
+	if err != nil || nextOffset <= offset {
+		c.debugf("doRequest: next link %v has no usable offset, paging sequentially", next.URI)
+		return c.followPages(ctx, next.URI, t, f)
+	}
+
+	resume, err := c.prefetch(ctx, u, nextOffset, nextOffset-offset, t, f)
+	if err != nil || resume == "" {
+		return err
+	}
+	c.debugf("doRequest: unexpected next link %v, paging sequentially", resume)
+	return c.followPages(ctx, resume, t, f)
+}
+
+// prefetch fetches the pages of u from offset on, step IOCs apart, and
+// passes them to f in order. If a page links to another offset than the
+// following one, prefetching stops and that link is returned.
+//
+// At most parallelPages pages are requested ahead of the one passed to f.
+// Once a page without next link has been fetched, no further pages are
+// requested. As the total is not known in advance, up to parallelPages-1
+// requests beyond it may already be under way, fewer with a page delay.
+func (c *Client) prefetch(ctx context.Context, u *url.URL, offset, step int, t MimeType, f func(io.Reader) error) (string, error) {
+	outer := ctx
+
+	// Pending requests are cancelled and waited for on return, so nothing
+	// is left running in the background
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	// last is closed once a page ends the prefetching, as it failed, has
+	// no next link or links to an unexpected offset
+	last := make(chan struct{})
+	var lastOnce sync.Once
+	stop := func(p *prefetchPage) {
+		if p.err == nil && p.next != nil {
+			if next, err := pageOffset(p.next.URI); err == nil && next == p.offset+step {
+				return
+			}
+		}
+		lastOnce.Do(func() { close(last) })
+	}
+
+	// Pages are queued in order and fetched by one goroutine each. A slot
+	// is held from the request of a page until it is passed to f.
+	pages := make(chan *prefetchPage, c.parallelPages)
+	slots := make(chan struct{}, c.parallelPages)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pages)
+		for offset := offset; ; offset += step {
+			select {
+			case slots <- struct{}{}:
+			case <-last:
+				return
+			case <-ctx.Done():
+				return
+			}
+			// Requests are paced here rather than by the fetching
+			// goroutines, so they are sent in order and the last page
+			// may arrive while waiting
+			if err := c.pacer.wait(ctx, c.pageDelay); err != nil {
+				return
+			}
+			select {
+			case <-last:
+				return
+			default:
+			}
+
+			p := &prefetchPage{offset: offset, done: make(chan struct{})}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(p.done)
+
+				pu := pageURL(u, p.offset)
+				c.debugf("doRequest: GET %v", pu)
+				p.next, p.err = c.retryIteration(ctx, pu, t, &p.buf)
+				stop(p)
+			}()
+
+			// The slots keep the queue from filling up
+			pages <- p
+		}
+	}()
+
+	for p := range pages {
+		<-p.done
+		if p.err != nil {
+			if outer.Err() != nil {
+				return "", outer.Err()
+			}
+			return "", p.err
+		}
+		if err := f(&p.buf); outer.Err() != nil {
+			return "", outer.Err()
+		} else if err != nil {
+			return "", fmt.Errorf("f: %v", err)
+		}
+		<-slots
+		if p.next == nil {
+			return "", nil
+		}
+		if next, err := pageOffset(p.next.URI); err != nil || next != p.offset+step {
+			return p.next.URI, nil
+		}
+	}
+
+	return "", outer.Err()
+}
@@ -0,0 +1,98 @@
+package gotie_test
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DCSO/gotie/v1"
+	"github.com/DCSO/gotie/v1/tietest"
+)
+
+// prefetchServer serves n domain name IOCs with IDs ioc-0 and up
+func prefetchServer(n int) *tietest.Server {
+	iocs := make([]gotie.IOC, n)
+	for i := range iocs {
+		iocs[i] = gotie.IOC{
+			ID:       fmt.Sprintf("ioc-%d", i),
+			Value:    fmt.Sprintf("host%d.example.com", i),
+			DataType: "DomainName",
+		}
+	}
+	return tietest.NewServer(iocs...)
+}
+
+// pagesBeyond returns the number of requests of srv for offsets past last
+func pagesBeyond(t *testing.T, srv *tietest.Server, last int) int {
+	n := 0
+	for _, r := range srv.Requests() {
+		u, err := url.Parse(r)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		offset, _ := strconv.Atoi(u.Query().Get("offset"))
+		if offset > last {
+			n++
+		}
+	}
+	return n
+}
+
+// writeIOCs returns the JSON output of all IOCs served to client
+func writeIOCs(t *testing.T, client *gotie.Client) string {
+	var buf bytes.Buffer
+	if err := client.WriteIOCs("", "DomainName", "", "json", &buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	return buf.String()
+}
+
+func TestPrefetchLastPage(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		delay   time.Duration
+		maxLate int
+	}{
+		// Requests are spaced apart, so the last page is known before
+		// the next request is sent
+		{"paced", 50 * time.Millisecond, 0},
+		{"unpaced", 0, 2},
+	} {
+		srv := prefetchServer(9)
+		expected := writeIOCs(t, srv.NewClient(gotie.WithIOCLimit(2), gotie.WithPageDelay(0)))
+		got := writeIOCs(t, srv.NewClient(gotie.WithIOCLimit(2), gotie.WithParallelPages(3), gotie.WithPageDelay(c.delay)))
+		srv.Close()
+
+		if got != expected {
+			t.Fatalf("%s: parallel output differs:\n%s\nsequential:\n%s", c.name, got, expected)
+		}
+		if n := pagesBeyond(t, srv, 8); n > c.maxLate {
+			t.Fatalf("%s: %d requests past the last page, expected at most %d: %v", c.name, n, c.maxLate, srv.Requests())
+		}
+	}
+}
+
+func TestPrefetchCappedPages(t *testing.T) {
+	srv := prefetchServer(7)
+	defer srv.Close()
+	srv.SetMaxLimit(2)
+
+	expected := writeIOCs(t, srv.NewClient(gotie.WithIOCLimit(5), gotie.WithPageDelay(0)))
+	got := writeIOCs(t, srv.NewClient(gotie.WithIOCLimit(5), gotie.WithParallelPages(4), gotie.WithPageDelay(50*time.Millisecond)))
+	if got != expected {
+		t.Fatalf("capped parallel output differs:\n%s\nsequential:\n%s", got, expected)
+	}
+	if !strings.Contains(got, "ioc-6") {
+		t.Fatalf("last IOC missing from output:\n%s", got)
+	}
+	if n := pagesBeyond(t, srv, 6); n > 0 {
+		t.Fatalf("%d requests past the last page: %v", n, srv.Requests())
+	}
+}
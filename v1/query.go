@@ -0,0 +1,306 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MinSeverity and MaxSeverity bound the severity of TIE IOCs
+	MinSeverity = 0
+	MaxSeverity = 5
+	// MinConfidence and MaxConfidence bound the confidence of TIE IOCs
+	MinConfidence = 0
+	MaxConfidence = 100
+)
+
+// queryTimeFormat is the date format used for date window parameters
+const queryTimeFormat = "2006-01-02T15:04:05Z"
+
+// Range is an inclusive range of integer values as used for severity and
+// confidence filters. A negative Max leaves the range open ended.
+type Range struct {
+	Min int
+	Max int
+}
+
+// ParseRange parses ranges of the form "3", "2-4", "2-" or "-4".
+func ParseRange(s string) (r Range, err error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "-", 2)
+	if parts[0] == "" && (len(parts) == 1 || parts[1] == "") {
+		return r, fmt.Errorf("invalid range %q", s)
+	}
+
+	if parts[0] != "" {
+		if r.Min, err = strconv.Atoi(parts[0]); err != nil {
+			return r, fmt.Errorf("invalid range %q", s)
+		}
+	}
+
+	switch {
+	case len(parts) == 1:
+		r.Max = r.Min
+	case parts[1] == "":
+		r.Max = -1
+	default:
+		if r.Max, err = strconv.Atoi(parts[1]); err != nil {
+			return r, fmt.Errorf("invalid range %q", s)
+		}
+	}
+
+	return r, nil
+}
+
+func (r Range) String() string {
+	switch {
+	case r.Max < 0:
+		return strconv.Itoa(r.Min) + "-"
+	case r.Min == r.Max:
+		return strconv.Itoa(r.Min)
+	default:
+		return strconv.Itoa(r.Min) + "-" + strconv.Itoa(r.Max)
+	}
+}
+
+// Contains reports whether v is within the range
+func (r Range) Contains(v int) bool {
+	return v >= r.Min && (r.Max < 0 || v <= r.Max)
+}
+
+func (r Range) validate(name string, min, max int) error {
+	if r.Min < min || r.Min > max || r.Max > max || (r.Max >= 0 && r.Max < r.Min) {
+		return fmt.Errorf("invalid %v range %v, must be within %v-%v", name, r, min, max)
+	}
+	return nil
+}
+
+// IOCQuery holds optional filter and ordering parameters for IOC and feed
+// requests. The setters can be chained and validate their arguments; the
+// first invalid argument is reported by Values.
+//
+//	q := NewIOCQuery().Severity(Range{Min: 3, Max: -1}).Categories("c2server")
+type IOCQuery struct {
+	values url.Values
+	err    error
+	since  map[string]time.Time
+	until  map[string]time.Time
+}
+
+// NewIOCQuery returns an empty query
+func NewIOCQuery() *IOCQuery {
+	return &IOCQuery{
+		values: url.Values{},
+		since:  map[string]time.Time{},
+		until:  map[string]time.Time{},
+	}
+}
+
+func (q *IOCQuery) fail(err error) *IOCQuery {
+	if q.err == nil {
+		q.err = err
+	}
+	return q
+}
+
+// Severity restricts the query to IOCs with a severity within r
+func (q *IOCQuery) Severity(r Range) *IOCQuery {
+	if err := r.validate("severity", MinSeverity, MaxSeverity); err != nil {
+		return q.fail(err)
+	}
+	q.values.Set("severity", r.String())
+	return q
+}
+
+// Confidence restricts the query to IOCs with a confidence within r
+func (q *IOCQuery) Confidence(r Range) *IOCQuery {
+	if err := r.validate("confidence", MinConfidence, MaxConfidence); err != nil {
+		return q.fail(err)
+	}
+	q.values.Set("confidence", r.String())
+	return q
+}
+
+func (q *IOCQuery) setList(key string, list []string) *IOCQuery {
+	for _, item := range list {
+		if item == "" || strings.Contains(item, ",") {
+			return q.fail(fmt.Errorf("invalid %v %q", key, item))
+		}
+	}
+	if len(list) > 0 {
+		q.values.Set(key, strings.Join(list, ","))
+	}
+	return q
+}
+
+// Categories restricts the query to IOCs of at least one of the given
+// categories
+func (q *IOCQuery) Categories(categories ...string) *IOCQuery {
+	return q.setList("category", categories)
+}
+
+// SourcePseudonyms restricts the query to IOCs reported by one of the given
+// sources
+func (q *IOCQuery) SourcePseudonyms(pseudonyms ...string) *IOCQuery {
+	return q.setList("source_pseudonym", pseudonyms)
+}
+
+func (q *IOCQuery) setTime(field string, since bool, t time.Time) *IOCQuery {
+	if since {
+		q.since[field] = t
+		q.values.Set(field+"_since", t.UTC().Format(queryTimeFormat))
+	} else {
+		q.until[field] = t
+		q.values.Set(field+"_until", t.UTC().Format(queryTimeFormat))
+	}
+	return q
+}
+
+// CreatedSince restricts the query to IOCs created at or after t
+func (q *IOCQuery) CreatedSince(t time.Time) *IOCQuery {
+	return q.setTime("created", true, t)
+}
+
+// CreatedUntil restricts the query to IOCs created at or before t
+func (q *IOCQuery) CreatedUntil(t time.Time) *IOCQuery {
+	return q.setTime("created", false, t)
+}
+
+// UpdatedSince restricts the query to IOCs updated at or after t
+func (q *IOCQuery) UpdatedSince(t time.Time) *IOCQuery {
+	return q.setTime("updated", true, t)
+}
+
+// UpdatedUntil restricts the query to IOCs updated at or before t
+func (q *IOCQuery) UpdatedUntil(t time.Time) *IOCQuery {
+	return q.setTime("updated", false, t)
+}
+
+// FirstSeenSince restricts the query to IOCs first seen at or after t
+func (q *IOCQuery) FirstSeenSince(t time.Time) *IOCQuery {
+	return q.setTime("first_seen", true, t)
+}
+
+// FirstSeenUntil restricts the query to IOCs first seen at or before t
+func (q *IOCQuery) FirstSeenUntil(t time.Time) *IOCQuery {
+	return q.setTime("first_seen", false, t)
+}
+
+// LastSeenSince restricts the query to IOCs last seen at or after t
+func (q *IOCQuery) LastSeenSince(t time.Time) *IOCQuery {
+	return q.setTime("last_seen", true, t)
+}
+
+// LastSeenUntil restricts the query to IOCs last seen at or before t
+func (q *IOCQuery) LastSeenUntil(t time.Time) *IOCQuery {
+	return q.setTime("last_seen", false, t)
+}
+
+// OrderBy sorts the results by the given IOC field in direction "asc" or
+// "desc"
+func (q *IOCQuery) OrderBy(field, direction string) *IOCQuery {
+	if field == "" {
+		return q.fail(errors.New("empty order_by field"))
+	}
+	if direction != "asc" && direction != "desc" {
+		return q.fail(fmt.Errorf("invalid direction %q, must be asc or desc", direction))
+	}
+	q.values.Set("order_by", field)
+	q.values.Set("direction", direction)
+	return q
+}
+
+// Offset skips the first n results
+func (q *IOCQuery) Offset(n int) *IOCQuery {
+	if n < 0 {
+		return q.fail(fmt.Errorf("invalid offset %d", n))
+	}
+	q.values.Set("offset", strconv.Itoa(n))
+	return q
+}
+
+// GroupBy aggregates the results by the given IOC fields
+func (q *IOCQuery) GroupBy(fields ...string) *IOCQuery {
+	return q.setList("group_by", fields)
+}
+
+// WithCompositions includes the compositions of composite IOCs
+func (q *IOCQuery) WithCompositions(with bool) *IOCQuery {
+	q.values.Set("with_compositions", strconv.FormatBool(with))
+	return q
+}
+
+// Enriched restricts the query to IOCs which have or have not been enriched
+func (q *IOCQuery) Enriched(enriched bool) *IOCQuery {
+	q.values.Set("enriched", strconv.FormatBool(enriched))
+	return q
+}
+
+// Bloom sets capacity n and false positive rate p of Bloom filters returned
+// by TIE
+func (q *IOCQuery) Bloom(n uint64, p float64) *IOCQuery {
+	if n == 0 {
+		return q.fail(errors.New("bloom capacity must be positive"))
+	}
+	if p <= 0 || p >= 1 {
+		return q.fail(fmt.Errorf("invalid bloom false positive rate %v", p))
+	}
+	q.values.Set("n", strconv.FormatUint(n, 10))
+	q.values.Set("p", strconv.FormatFloat(p, 'g', -1, 64))
+	return q
+}
+
+// Clone returns a copy of the query which can be modified independently
+func (q *IOCQuery) Clone() *IOCQuery {
+	c := NewIOCQuery()
+	if q == nil {
+		return c
+	}
+
+	c.err = q.err
+	for k, v := range q.values {
+		c.values[k] = append([]string(nil), v...)
+	}
+	for k, t := range q.since {
+		c.since[k] = t
+	}
+	for k, t := range q.until {
+		c.until[k] = t
+	}
+	return c
+}
+
+// Values returns the query parameters, or the first validation error
+func (q *IOCQuery) Values() (url.Values, error) {
+	if q == nil {
+		return url.Values{}, nil
+	}
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	for field, since := range q.since {
+		if until, ok := q.until[field]; ok && until.Before(since) {
+			return nil, fmt.Errorf("%v_until is before %v_since", field, field)
+		}
+	}
+
+	values := url.Values{}
+	for k, v := range q.values {
+		values[k] = append([]string(nil), v...)
+	}
+
+	return values, nil
+}
+
+// Err returns the first validation error of the query
+func (q *IOCQuery) Err() error {
+	_, err := q.Values()
+	return err
+}
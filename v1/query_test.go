@@ -0,0 +1,158 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	for s, expected := range map[string]Range{
+		"3":   {3, 3},
+		"2-4": {2, 4},
+		"2-":  {2, -1},
+		"-4":  {0, 4},
+	} {
+		r, err := ParseRange(s)
+		if err != nil {
+			t.Errorf("%v: %v", s, err)
+			continue
+		}
+		if r != expected {
+			t.Errorf("%v: expected %v, got %v", s, expected, r)
+		}
+		if s != "-4" && r.String() != s {
+			t.Errorf("%v: formatted as %v", s, r)
+		}
+	}
+
+	for _, s := range []string{"", "a", "1-b", "1-2-3"} {
+		if _, err := ParseRange(s); err == nil {
+			t.Errorf("%v: expected error", s)
+		}
+	}
+}
+
+func TestIOCQueryValidation(t *testing.T) {
+	now := time.Now()
+
+	for name, q := range map[string]*IOCQuery{
+		"severity":   NewIOCQuery().Severity(Range{2, 9}),
+		"confidence": NewIOCQuery().Confidence(Range{80, 20}),
+		"category":   NewIOCQuery().Categories("a,b"),
+		"direction":  NewIOCQuery().OrderBy("value", "up"),
+		"window":     NewIOCQuery().CreatedSince(now).CreatedUntil(now.Add(-time.Hour)),
+		"bloom":      NewIOCQuery().Bloom(1000, 1.5),
+	} {
+		if q.Err() == nil {
+			t.Errorf("%v: expected validation error", name)
+		}
+	}
+}
+
+func TestIOCRequestURL(t *testing.T) {
+	since := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	r := &IOCRequest{
+		Query:    "a&b=c",
+		DataType: "DomainName",
+		Args: NewIOCQuery().
+			Severity(Range{3, -1}).
+			Categories("c2server", "phishing").
+			FirstSeenSince(since).
+			OrderBy("value", "asc"),
+	}
+
+	u, err := r.URL("https://tie.example.com/api/v1/", 100)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if parsed.Path != "/api/v1/iocs" {
+		t.Errorf("unexpected path %v", parsed.Path)
+	}
+
+	for k, v := range map[string]string{
+		"ivalue":           "a&b=c",
+		"data_type":        "domainname",
+		"limit":            "100",
+		"severity":         "3-",
+		"category":         "c2server,phishing",
+		"first_seen_since": "2018-03-01T12:00:00Z",
+		"order_by":         "value",
+		"direction":        "asc",
+	} {
+		if got := parsed.Query().Get(k); got != v {
+			t.Errorf("%v: expected %q, got %q", k, v, got)
+		}
+	}
+
+	r.Args.Severity(Range{7, 7})
+	if _, err := r.URL("https://tie.example.com/api/v1/", 100); err == nil {
+		t.Errorf("expected invalid query to fail")
+	}
+}
+
+func TestQueryFunctions(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		queries []url.Values
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.Query())
+		mu.Unlock()
+		w.Write([]byte(`{"iocs": [{"id": "1", "value": "a.example.com"}], "has_more": false}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(WithAPIURL(srv.URL + "/"))
+	ctx := context.Background()
+	args := NewIOCQuery().Severity(Range{Min: 3, Max: -1}).Categories("c2server")
+
+	res, err := c.GetIOCsQuery(ctx, "example", "domainname", args)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(res.Iocs) != 1 {
+		t.Fatalf("unexpected IOCs %v", res.Iocs)
+	}
+	if _, err := c.GetIOCPeriodFeedsQuery(ctx, "daily", "domainname", args); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := c.WriteIOCsQuery(ctx, "example", "domainname", args, "json", ioutil.Discard); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := c.WritePeriodFeedsQuery(ctx, "daily", "domainname", nil, "json", ioutil.Discard); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(queries) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(queries))
+	}
+	for i, q := range queries[:3] {
+		if q.Get("severity") != "3-" || q.Get("category") != "c2server" {
+			t.Errorf("request %d: query parameters missing in %v", i, q)
+		}
+	}
+	if q := queries[3]; q.Get("severity") != "" || q.Get("limit") == "" {
+		t.Errorf("unexpected parameters without query %v", q)
+	}
+
+	bad := NewIOCQuery().Severity(Range{Min: 7, Max: 7})
+	if _, err := c.GetIOCsQuery(ctx, "example", "domainname", bad); err == nil {
+		t.Errorf("expected invalid query to fail")
+	}
+}
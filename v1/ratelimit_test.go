@@ -0,0 +1,99 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := NewRateLimiter(10, 2)
+	now := time.Now()
+
+	for i, want := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if wait := l.reserve(now); wait != want {
+			t.Fatalf("request %d: expected wait %v, got %v", i, want, wait)
+		}
+	}
+
+	// the bucket refills at the rate, but never beyond the burst
+	if wait := l.reserve(now.Add(time.Hour)); wait != 0 {
+		t.Fatalf("expected refilled bucket, got wait %v", wait)
+	}
+	if wait := l.reserve(now.Add(time.Hour)); wait != 0 {
+		t.Fatalf("expected refilled bucket, got wait %v", wait)
+	}
+	if wait := l.reserve(now.Add(time.Hour)); wait != 100*time.Millisecond {
+		t.Fatalf("expected burst of 2, got wait %v", wait)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	var l *RateLimiter
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("nil limiter: %v", err)
+	}
+
+	l = NewRateLimiter(0.001, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if l.tokens < 0 {
+		t.Fatalf("aborted wait kept its token, %v left", l.tokens)
+	}
+}
+
+func TestSharedRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"params": {"has_more": false}, "iocs": []}`))
+	}))
+	defer srv.Close()
+
+	limiter := NewRateLimiter(50, 1)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		client := NewClient(
+			WithAPIURL(srv.URL+"/"),
+			WithPingbackURL(srv.URL+"/submit/"),
+			WithPageDelay(0),
+			WithRateLimiter(limiter),
+		)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := client.Do(&IOCRequest{DataType: "domainname"}, JSON, ioutil.Discard); err != nil {
+				t.Errorf(err.Error())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := client.SubmitSighting(Sighting{DataType: "DomainName", Value: "example.com"}); err != nil {
+				t.Errorf(err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+
+	// six requests at 50/s with a burst of one take at least 100ms
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("six requests took only %v", d)
+	}
+}
@@ -5,14 +5,16 @@ package gotie
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	link "github.com/tent/http-link-go"
@@ -30,112 +32,344 @@ func NewMimeType(outputFormat string) (t MimeType, err error) {
 		return CSV, nil
 	case "json":
 		return JSON, nil
+	case "ndjson":
+		return NDJSON, nil
 	case "stix":
 		return STIX, nil
+	case "stix2":
+		return STIX2, nil
+	case "misp":
+		return MISP, nil
+	case "suricata":
+		return SURICATA, nil
+	case "snort":
+		return SNORT, nil
+	case "zeek":
+		return ZEEK, nil
+	case "rpz":
+		return RPZ, nil
 	default:
 		return t, errors.New("Unsupported output format requested: " + outputFormat)
 	}
 }
 
 func (t MimeType) Aggregator() PageContentAggregator {
+	return t.aggregator(nil)
+}
+
+// aggregator is Aggregator with the aggregators and IOCWriters logging to
+// l, if set
+func (t MimeType) aggregator(l *log.Logger) PageContentAggregator {
 	switch t {
 	case BLOOMv1:
-		return &BloomPageAggregator{}
+		return &BloomPageAggregator{Logger: l}
 	case BLOOMv2:
-		return &BloomPageAggregator{}
+		return &BloomPageAggregator{Logger: l}
 	case CSV:
-		return &PaginatedRawPageAggregator{}
+		return newBufferedAggregator(func(w io.Writer) PageContentAggregator {
+			return NewCSVStreamAggregator(w)
+		})
 	case JSON:
 		return &JSONPageAggregator{}
+	case NDJSON:
+		return newBufferedAggregator(func(w io.Writer) PageContentAggregator {
+			return NewNDJSONAggregator(w)
+		})
 	case STIX:
-		return &PaginatedRawPageAggregator{}
+		return &STIXPageAggregator{}
 	default:
+		if newWriter, ok := t.newIOCWriter(l); ok {
+			return iocWriterAggregator(newWriter)
+		}
 		panic(fmt.Sprintf("unknown type %v", t))
 	}
 }
 
+// StreamAggregator returns an aggregator writing each page to w as soon as
+// it arrives. Formats which can not be streamed are collected in memory and
+// written on Finish.
+func (t MimeType) StreamAggregator(w io.Writer) PageContentAggregator {
+	return t.streamAggregator(w, nil)
+}
+
+// streamAggregator is StreamAggregator logging to l, if set
+func (t MimeType) streamAggregator(w io.Writer, l *log.Logger) PageContentAggregator {
+	switch t {
+	case CSV:
+		return NewCSVStreamAggregator(w)
+	case JSON:
+		return NewJSONStreamAggregator(w)
+	case NDJSON:
+		return NewNDJSONAggregator(w)
+	default:
+		if newWriter, ok := t.newIOCWriter(l); ok {
+			return NewIOCWriterAggregator(w, newWriter)
+		}
+		return t.aggregator(l)
+	}
+}
+
+// newIOCWriter returns the constructor of the IOCWriter generating t, with
+// the writers logging to l
+func (t MimeType) newIOCWriter(l *log.Logger) (func(io.Writer) IOCWriter, bool) {
+	newWriter, ok := iocWriters[t]
+	if !ok {
+		return nil, false
+	}
+	return func(w io.Writer) IOCWriter {
+		iw := newWriter(w)
+		SetWriterLogger(iw, l)
+		return iw
+	}, true
+}
+
+// Accept returns the type requested from TIE for output format t. Formats
+// generated locally are built from JSON.
+func (t MimeType) Accept() MimeType {
+	if _, ok := iocWriters[t]; ok || t == NDJSON {
+		return JSON
+	}
+	return t
+}
+
 func (t MimeType) String() string {
 	return string(t)
 }
 
 const (
-	JSON    MimeType = "application/json"
-	CSV     MimeType = "text/csv"
-	BLOOMv1 MimeType = "application/bloom"
-	BLOOMv2 MimeType = "application/bloom-v2"
-	STIX    MimeType = "text/xml"
+	JSON     MimeType = "application/json"
+	CSV      MimeType = "text/csv"
+	BLOOMv1  MimeType = "application/bloom"
+	BLOOMv2  MimeType = "application/bloom-v2"
+	STIX     MimeType = "text/xml"
+	NDJSON   MimeType = "application/x-ndjson"
+	STIX2    MimeType = "application/stix+json;version=2.1"
+	MISP     MimeType = "application/vnd.misp+json"
+	SURICATA MimeType = "text/x-suricata-rules"
+	SNORT    MimeType = "text/x-snort-rules"
+	ZEEK     MimeType = "text/x-zeek-intel"
+	RPZ      MimeType = "text/x-rpz-zone"
 )
 
+// iocWriters holds the IOCWriters of the output formats which are generated
+// locally from JSON results
+var iocWriters = map[MimeType]func(io.Writer) IOCWriter{
+	STIX2: func(w io.Writer) IOCWriter {
+		return NewSTIX2Writer(w)
+	},
+	MISP: func(w io.Writer) IOCWriter {
+		return NewMISPWriter(w)
+	},
+	SURICATA: func(w io.Writer) IOCWriter {
+		return NewRuleWriter(w)
+	},
+	SNORT: func(w io.Writer) IOCWriter {
+		rw := NewRuleWriter(w)
+		rw.Dialect = RuleDialectSnort
+		return rw
+	},
+	ZEEK: func(w io.Writer) IOCWriter {
+		return NewZeekIntelWriter(w)
+	},
+	RPZ: func(w io.Writer) IOCWriter {
+		return NewRPZWriter(w)
+	},
+}
+
+// Request is a paginated TIE API request
 type Request interface {
+	// Url returns the address of the first result page
 	Url() string
 }
 
+// ClientRequest is a Request whose address is built from the API URL and
+// IOC limit of the Client doing it. Requests implementing only Request are
+// fetched from Url as is.
+type ClientRequest interface {
+	Request
+	// URL returns the address of the first result page below the API base
+	// URL apiURL, requesting at most limit IOCs per page.
+	URL(apiURL string, limit int) (string, error)
+}
+
+// requestURL returns the address of the first result page of r
+func requestURL(r Request, apiURL string, limit int) (string, error) {
+	if cr, ok := r.(ClientRequest); ok {
+		return cr.URL(apiURL, limit)
+	}
+	if u := r.Url(); u != "" {
+		return u, nil
+	}
+	return "", errors.New("request has no URL")
+}
+
+// queryValues returns the parameters common to IOC and feed requests
+func queryValues(args *IOCQuery, limit int) (url.Values, error) {
+	values, err := args.Values()
+	if err != nil {
+		return nil, err
+	}
+
+	values.Set("limit", strconv.Itoa(limit))
+	values.Set("date_format", "rfc3339")
+
+	return values, nil
+}
+
 type FeedRequest struct {
 	Request
 
 	FeedPeriod string
 	DataType   string
-	ExtraArgs  string
+	// Args holds optional query parameters
+	Args *IOCQuery
+	// ExtraArgs is appended to the query string verbatim and must start
+	// with "&".
+	//
+	// Deprecated: use Args instead.
+	ExtraArgs string
 	MimeType
 }
 
+func (r *FeedRequest) URL(apiURL string, limit int) (string, error) {
+	values, err := queryValues(r.Args, limit)
+	if err != nil {
+		return "", err
+	}
+
+	return apiURL + "iocs/feed/" + url.PathEscape(r.FeedPeriod) + "/" +
+		url.PathEscape(strings.ToLower(r.DataType)) +
+		"?" + values.Encode() +
+		r.ExtraArgs, nil
+}
+
+// Url returns the request URL based on the package level APIURL and IOCLimit,
+// or "" if the request is invalid, e.g. because of invalid Args.
+//
+// Deprecated: use URL instead, which reports why a request is invalid.
 func (r *FeedRequest) Url() string {
-	return APIURL + "iocs/feed/" + r.FeedPeriod + "/" + strings.ToLower(r.DataType) +
-		"?limit=" + strconv.Itoa(IOCLimit) +
-		"&date_format=rfc3339" +
-		r.ExtraArgs
+	u, _ := r.URL(APIURL, IOCLimit)
+	return u
 }
 
 type IOCRequest struct {
 	Request
 
-	Query     string
-	DataType  string
+	Query    string
+	DataType string
+	// Args holds optional query parameters
+	Args *IOCQuery
+	// ExtraArgs is appended to the query string verbatim and must start
+	// with "&".
+	//
+	// Deprecated: use Args instead.
 	ExtraArgs string
 	MimeType
 }
 
-func (r *IOCRequest) Url() string {
-	return APIURL +
-		"iocs?data_type=" + strings.ToLower(r.DataType) +
-		"&ivalue=" + r.Query +
-		"&limit=" + strconv.Itoa(IOCLimit) +
-		"&date_format=rfc3339" +
-		r.ExtraArgs
+func (r *IOCRequest) URL(apiURL string, limit int) (string, error) {
+	values, err := queryValues(r.Args, limit)
+	if err != nil {
+		return "", err
+	}
 
+	values.Set("data_type", strings.ToLower(r.DataType))
+	values.Set("ivalue", r.Query)
+
+	return apiURL + "iocs?" + values.Encode() + r.ExtraArgs, nil
+}
+
+// Url returns the request URL based on the package level APIURL and IOCLimit,
+// or "" if the request is invalid, e.g. because of invalid Args.
+//
+// Deprecated: use URL instead, which reports why a request is invalid.
+func (r *IOCRequest) Url() string {
+	u, _ := r.URL(APIURL, IOCLimit)
+	return u
 }
 
 // Do request and write result into w.
-func Do(r Request, t MimeType, w io.Writer) (err error) {
-	agg := t.Aggregator()
-	defer agg.Finish(w)
+func (c *Client) Do(r Request, t MimeType, w io.Writer) (err error) {
+	return c.DoContext(context.Background(), r, t, w)
+}
 
-	err = doRequest(r, t, func(buf io.Reader) error {
-		agg.AddPage(buf)
-		return nil
+// DoContext does the request and writes the result into w. Cancelling ctx
+// aborts the request, including any pending wait between pages or retries.
+func (c *Client) DoContext(ctx context.Context, r Request, t MimeType, w io.Writer) (err error) {
+	var agg PageContentAggregator
+	if c.streaming {
+		agg = t.streamAggregator(w, c.debugLogger())
+	} else {
+		agg = t.aggregator(c.debugLogger())
+	}
+
+	return c.AggregateContext(ctx, r, t, agg, w)
+}
+
+// Aggregate does the request and passes the result pages to agg, which is
+// finished by writing to w. It allows to use aggregators configured
+// differently from the ones returned by MimeType.Aggregator.
+func (c *Client) Aggregate(r Request, t MimeType, agg PageContentAggregator, w io.Writer) error {
+	return c.AggregateContext(context.Background(), r, t, agg, w)
+}
+
+// AggregateContext is like Aggregate but aborts the request when ctx is
+// cancelled.
+func (c *Client) AggregateContext(ctx context.Context, r Request, t MimeType, agg PageContentAggregator, w io.Writer) (err error) {
+	err = c.doRequest(ctx, r, t, func(buf io.Reader) error {
+		return agg.AddPage(buf)
 	})
 
+	if ferr := agg.Finish(w); err == nil {
+		err = ferr
+	}
+
 	return
 }
 
-func DoCh(r Request, t MimeType, ch chan<- IOCResult) {
-	var iocResult IOCResult
+// Do request using the package level configuration and write result into w.
+func Do(r Request, t MimeType, w io.Writer) (err error) {
+	return defaultClient().Do(r, t, w)
+}
 
-	err := doRequest(r, t, func(buf io.Reader) error {
-		dec := json.NewDecoder(buf)
-		if err := dec.Decode(&iocResult.IOC); err != nil {
-			ch <- IOCResult{IOC: nil, Error: err}
-			close(ch)
-			return nil
-		}
+// DoContext does the request using the package level configuration and
+// writes the result into w.
+func DoContext(ctx context.Context, r Request, t MimeType, w io.Writer) (err error) {
+	return defaultClient().DoContext(ctx, r, t, w)
+}
 
-		ch <- iocResult
+func (c *Client) DoCh(r Request, t MimeType, ch chan<- IOCResult) {
+	c.DoChContext(context.Background(), r, t, ch)
+}
 
-		return nil
+// DoChContext does the request and sends each IOC of the JSON result pages
+// to ch as a separate IOCResult, along with the metadata of its page. ch is
+// closed when the request is done. If ctx is cancelled, the request is
+// aborted and ctx.Err() is sent as the last result if ch has room or is
+// being read; consumers which stop reading after cancelling ctx do not block
+// DoChContext.
+func (c *Client) DoChContext(ctx context.Context, r Request, t MimeType, ch chan<- IOCResult) {
+	err := c.doRequest(ctx, r, t, func(buf io.Reader) error {
+		return readIOCPage(buf, func(ioc *IOC, page *IOCPage) error {
+			select {
+			case ch <- IOCResult{IOC: ioc, Page: page}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
 	})
 
 	if err != nil {
-		ch <- IOCResult{IOC: nil, Error: err}
+		res := IOCResult{IOC: nil, Error: err}
+		select {
+		case ch <- res:
+		default:
+			select {
+			case ch <- res:
+			case <-ctx.Done():
+			}
+		}
 	}
 
 	close(ch)
@@ -143,95 +377,152 @@ func DoCh(r Request, t MimeType, ch chan<- IOCResult) {
 	return
 }
 
-func doRequest(r Request, t MimeType, f func(io.Reader) error) (err error) {
-	url := r.Url()
+func DoCh(r Request, t MimeType, ch chan<- IOCResult) {
+	defaultClient().DoCh(r, t, ch)
+}
+
+func DoChContext(ctx context.Context, r Request, t MimeType, ch chan<- IOCResult) {
+	defaultClient().DoChContext(ctx, r, t, ch)
+}
+
+func (c *Client) doRequest(ctx context.Context, r Request, t MimeType, f func(io.Reader) error) (err error) {
+	url, err := requestURL(r, c.apiURL, c.iocLimit)
+	if err != nil {
+		return err
+	}
+	if c.parallelPages > 1 {
+		return c.doRequestParallel(ctx, url, t, f)
+	}
+	return c.followPages(ctx, url, t, f)
+}
 
+// followPages fetches the page at url and the following ones one after the
+// other, as linked by TIE, and passes them to f
+func (c *Client) followPages(ctx context.Context, url string, t MimeType, f func(io.Reader) error) error {
 	buf := bytes.NewBuffer([]byte{})
 
 	for {
-		if Debug {
-			log.Printf("doRequest: GET %v", url)
-		}
+		c.debugf("doRequest: GET %v", url)
 
-		next, err := doIteration(url, t, buf)
-		if err != nil {
+		next, err := c.doIteration(ctx, url, t, buf)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
 			return err
 		}
 
-		if err := f(buf); err != nil {
+		if err := f(buf); ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
 			return fmt.Errorf("f: %v", err)
 		}
 
-		if next != nil {
-			url = next.URI
-		} else {
-			break
+		if next == nil {
+			return nil
 		}
+		url = next.URI
 
 		buf.Reset()
 	}
+}
 
-	return
+// sleep waits for the duration d or until ctx is done, whichever happens
+// first. It returns ctx.Err() if the wait was aborted.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func doIteration(url string, t MimeType, w io.Writer) (next *link.Link, err error) {
-	var code int
-	var waitFail = WAIT_FAIL_DURATION_SECONDS * time.Second
+// pacer spaces the requests of a client, shared by all its goroutines
+type pacer struct {
+	mu   sync.Mutex
+	next time.Time
+}
 
-	<-time.After(WAIT_DURATION_MILLISECONDS * time.Millisecond)
+// wait blocks until the next request may be started, at least d after the
+// previous one
+func (p *pacer) wait(ctx context.Context, d time.Duration) error {
+	p.mu.Lock()
+	now := time.Now()
+	at := p.next
+	if at.Before(now) {
+		at = now
+	}
+	p.next = at.Add(d)
+	p.mu.Unlock()
 
-	for i := 0; i < MAX_RETRIES; i++ {
-		code, next, err = mustDoIteration(url, t, w)
-		if code >= 500 {
-			log.Printf("Status code %v (%v): retrying in %v...", code, err, waitFail)
-			<-time.After(waitFail)
-			waitFail *= 2
-		} else if err != nil {
+	if at.Equal(now) {
+		return ctx.Err()
+	}
+	return sleep(ctx, at.Sub(now))
+}
+
+func (c *Client) doIteration(ctx context.Context, url string, t MimeType, w io.Writer) (next *link.Link, err error) {
+	if err = c.pacer.wait(ctx, c.pageDelay); err != nil {
+		return nil, err
+	}
+	return c.retryIteration(ctx, url, t, w)
+}
+
+// retryIteration is doIteration without waiting for the pacer
+func (c *Client) retryIteration(ctx context.Context, url string, t MimeType, w io.Writer) (next *link.Link, err error) {
+	for attempt := 1; ; attempt++ {
+		// Each attempt is read into its own buffer, so a response cut
+		// off while reading the body is not passed on to w in part
+		var page bytes.Buffer
+		_, next, err = c.mustDoIteration(ctx, url, t, &page)
+		if err == nil {
+			_, err = page.WriteTo(w)
+			return
+		} else if ctx.Err() != nil {
+			return
+		}
+
+		wait, retry := c.retryPolicy.Retry(attempt, err)
+		if !retry {
+			if attempt > 1 {
+				c.logger.Printf("Giving up after %d attempts", attempt)
+			}
+			return nil, err
+		}
+		c.logger.Printf("Attempt %d failed (%v): retrying in %v...", attempt, err, wait)
+		if err := sleep(ctx, wait); err != nil {
 			return nil, err
-		} else {
-			break
 		}
 	}
-
-	return
 }
 
-func mustDoIteration(url string, t MimeType, w io.Writer) (code int, next *link.Link, err error) {
+func (c *Client) mustDoIteration(ctx context.Context, url string, t MimeType, w io.Writer) (code int, next *link.Link, err error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return
 	}
+	req = req.WithContext(ctx)
 
-	req.Header.Add("Accept", t.String())
-	req.Header.Add("Authorization", "Bearer "+AuthToken)
+	req.Header.Add("Accept", t.Accept().String())
+	req.Header.Add("Authorization", "Bearer "+c.authToken)
 
-	if Debug {
-		log.Printf("GET %v", url)
-	}
+	c.debugf("GET %v", url)
 
-	resp, err := client.Do(req)
+	if err = c.limiter.Wait(ctx); err != nil {
+		return
+	}
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
-	// Error handling for various Content types
 	if code = resp.StatusCode; code > 299 {
-		log.Printf("resp header: %v", resp.Header)
-
-		if t := resp.Header.Get("Content-Type"); strings.Contains(t, string(JSON)) {
-			var msg apiMessage
-
-			err = json.NewDecoder(resp.Body).Decode(&msg)
-			if err != nil {
-				return
-			}
-			return code, nil, fmt.Errorf("TIE returned an error: %v %v", msg.Message, msg.Errors)
-		}
-
-		buf := &bytes.Buffer{}
-		io.Copy(buf, resp.Body)
-		return code, nil, fmt.Errorf("TIE returned an error: %v", buf.String())
+		c.debugf("resp header: %v", resp.Header)
+		return code, nil, newAPIError(resp)
 	}
 
 	// Body processing
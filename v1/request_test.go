@@ -0,0 +1,157 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoContextCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c := NewClient(WithAPIURL(srv.URL + "/"))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := c.WriteIOCsContext(ctx, "example", "domainname", "", "json", ioutil.Discard)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("request was not aborted in time (%v)", d)
+	}
+}
+
+func TestDoChContextCancelRetry(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := NewClient(WithAPIURL(srv.URL+"/"), WithRetries(3, time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := c.GetIOCChanContext(ctx, "example", "domainname", "")
+	time.AfterFunc(300*time.Millisecond, cancel)
+
+	var results []IOCResult
+	for res := range ch {
+		results = append(results, res)
+	}
+
+	if len(results) != 1 || results[0].Error != context.Canceled {
+		t.Errorf("expected single cancellation result, got %v", results)
+	}
+}
+
+func TestReadIOCPage(t *testing.T) {
+	page := `{"iocs": [{"id": "1", "value": "a.example.com"}, {"id": "2", "value": "b.example.com"}],
+		"extra": {"iocs": []}, "has_more": true, "params": {"limit": 2, "offset": 4}}`
+
+	var iocs []*IOC
+	err := readIOCPage(strings.NewReader(page), func(ioc *IOC, p *IOCPage) error {
+		if !p.HasMore || p.Params.Limit != 2 || p.Params.Offset != 4 {
+			t.Errorf("unexpected page metadata %+v", *p)
+		}
+		iocs = append(iocs, ioc)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(iocs) != 2 || iocs[0].Value != "a.example.com" || iocs[1].Value != "b.example.com" {
+		t.Fatalf("unexpected IOCs %v", iocs)
+	}
+
+	err = readIOCPage(strings.NewReader(`{"iocs": null, "has_more": false}`), func(*IOC, *IOCPage) error {
+		t.Errorf("IOC emitted for empty page")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	stop := errors.New("stop")
+	if err := readIOCPage(strings.NewReader(page), func(*IOC, *IOCPage) error { return stop }); err != stop {
+		t.Fatalf("expected emit error, got %v", err)
+	}
+
+	for _, bad := range []string{`[]`, `{"iocs": {}}`, `{"iocs": [{"id": 1}]}`, `{"iocs": [`} {
+		if err := readIOCPage(strings.NewReader(bad), func(*IOC, *IOCPage) error { return nil }); err == nil {
+			t.Errorf("%v: expected error", bad)
+		}
+	}
+}
+
+// rawRequest implements only the original Request interface
+type rawRequest string
+
+func (r rawRequest) Url() string { return string(r) }
+
+func TestDoRawRequest(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RequestURI()
+		w.Write([]byte(`{"iocs": []}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(WithAPIURL("http://unused.example.com/"), WithPageDelay(0))
+	if err := c.Do(rawRequest(srv.URL+"/custom?x=1"), JSON, ioutil.Discard); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if got != "/custom?x=1" {
+		t.Fatalf("expected Url to be fetched as is, got %v", got)
+	}
+
+	if err := c.Do(rawRequest(""), JSON, ioutil.Discard); err == nil {
+		t.Fatalf("expected error for empty Url")
+	}
+
+	invalid := &IOCRequest{DataType: "domainname", Args: NewIOCQuery().Severity(Range{Min: 9})}
+	if invalid.Url() != "" {
+		t.Fatalf("expected empty Url for invalid request, got %v", invalid.Url())
+	}
+	if err := c.Do(invalid, JSON, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "severity") {
+		t.Fatalf("expected invalid severity error, got %v", err)
+	}
+}
+
+func TestDoChContextAbandoned(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"iocs": [{"id": "1"}, {"id": "2"}, {"id": "3"}]}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(WithAPIURL(srv.URL+"/"), WithPageDelay(0))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := make(chan IOCResult)
+	done := make(chan struct{})
+	go func() {
+		c.DoChContext(ctx, &IOCRequest{DataType: "domainname"}, JSON, ch)
+		close(done)
+	}()
+
+	// stop reading after the first IOC
+	<-ch
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("DoChContext blocked after the consumer stopped reading")
+	}
+}
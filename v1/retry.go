@@ -0,0 +1,127 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides whether a failed request is repeated
+type RetryPolicy interface {
+	// Retry is called after the attempt-th attempt, counting from 1,
+	// failed with err. It returns whether to try again and how long to
+	// wait before.
+	Retry(attempt int, err error) (time.Duration, bool)
+}
+
+// BackoffPolicy is a RetryPolicy repeating requests which failed with a
+// transient network error, rate limiting or a server error. The wait
+// doubles after every attempt, starting with Wait, up to MaxWait.
+type BackoffPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a request
+	MaxAttempts int
+	// Wait is the wait after the first failed attempt
+	Wait time.Duration
+	// MaxWait limits the wait, unless zero. Requests are given up if TIE
+	// asks to wait longer.
+	MaxWait time.Duration
+	// Jitter is the fraction by which waits vary randomly, so clients
+	// failing at the same time do not retry in lockstep
+	Jitter float64
+}
+
+var (
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterMu   sync.Mutex
+)
+
+// Retry implements RetryPolicy. The wait requested by TIE in the
+// Retry-After header of a rate limited response takes precedence. If it is
+// longer than MaxWait, the request is not repeated, as retrying earlier
+// would be rejected again.
+func (p *BackoffPolicy) Retry(attempt int, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts || !IsTemporary(err) {
+		return 0, false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if p.MaxWait > 0 && apiErr.RetryAfter > p.MaxWait {
+			return 0, false
+		}
+		return apiErr.RetryAfter, true
+	}
+
+	wait := p.Wait
+	for i := 1; i < attempt && (p.MaxWait <= 0 || wait < p.MaxWait); i++ {
+		wait *= 2
+	}
+	if p.MaxWait > 0 && wait > p.MaxWait {
+		wait = p.MaxWait
+	}
+	if p.Jitter > 0 {
+		jitterMu.Lock()
+		f := 1 + p.Jitter*(2*jitterRand.Float64()-1)
+		jitterMu.Unlock()
+		wait = time.Duration(float64(wait) * f)
+	}
+	return wait, true
+}
+
+// DefaultRetryPolicy returns the retry policy of clients without
+// WithRetryPolicy
+func DefaultRetryPolicy() *BackoffPolicy {
+	return &BackoffPolicy{
+		MaxAttempts: MAX_RETRIES,
+		Wait:        WAIT_FAIL_DURATION_SECONDS * time.Second,
+		MaxWait:     5 * time.Minute,
+		Jitter:      0.2,
+	}
+}
+
+// IsTemporary reports whether a request failing with err may succeed when
+// repeated. That is the case for rate limiting, server errors, timeouts,
+// refused or reset connections and responses cut off early. Other network
+// errors, e.g. unknown hosts or invalid certificates, are permanent.
+func IsTemporary(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// parseRetryAfter returns the wait requested by a Retry-After header,
+// given in seconds or as date
+func parseRetryAfter(h http.Header, now time.Time) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
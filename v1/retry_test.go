@@ -0,0 +1,142 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicy(t *testing.T) {
+	p := &BackoffPolicy{MaxAttempts: 4, Wait: time.Second, MaxWait: 3 * time.Second}
+	serverError := &APIError{StatusCode: http.StatusBadGateway}
+
+	for _, tc := range []struct {
+		attempt int
+		err     error
+		wait    time.Duration
+		retry   bool
+	}{
+		{1, serverError, time.Second, true},
+		{2, serverError, 2 * time.Second, true},
+		{3, serverError, 3 * time.Second, true},
+		{4, serverError, 0, false},
+		{1, &APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: 2 * time.Second}, 2 * time.Second, true},
+		{1, &APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}, 0, false},
+		{1, &APIError{StatusCode: http.StatusUnauthorized}, 0, false},
+		{1, errors.New("invalid response"), 0, false},
+	} {
+		wait, retry := p.Retry(tc.attempt, tc.err)
+		if wait != tc.wait || retry != tc.retry {
+			t.Errorf("attempt %d, %v: expected %v %v, got %v %v", tc.attempt, tc.err, tc.wait, tc.retry, wait, retry)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if wait, _ := p.Retry(1, serverError); wait < 500*time.Millisecond || wait > 1500*time.Millisecond {
+			t.Fatalf("wait %v out of jitter range", wait)
+		}
+	}
+}
+
+func TestIsTemporary(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		temporary bool
+	}{
+		{nil, false},
+		{&APIError{StatusCode: http.StatusServiceUnavailable}, true},
+		{fmt.Errorf("wrapped: %w", &APIError{StatusCode: http.StatusNotFound}), false},
+		{&url.Error{Op: "Get", URL: "https://tie", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}, true},
+		{&url.Error{Op: "Get", URL: "https://tie", Err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, true},
+		{&url.Error{Op: "Get", URL: "https://tie", Err: &net.DNSError{Err: "no such host", Name: "tie"}}, false},
+		{&url.Error{Op: "Get", URL: "https://tie", Err: &net.DNSError{Err: "timeout", Name: "tie", IsTimeout: true}}, true},
+		{&url.Error{Op: "Get", URL: "https://tie", Err: x509.UnknownAuthorityError{}}, false},
+		{io.ErrUnexpectedEOF, true},
+		{errors.New("invalid response"), false},
+	} {
+		if temporary := IsTemporary(tc.err); temporary != tc.temporary {
+			t.Errorf("%v: expected %v, got %v", tc.err, tc.temporary, temporary)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"Thu, 01 Mar 2018 10:00:30 GMT": 30 * time.Second,
+		"Thu, 01 Mar 2018 09:00:00 GMT": 0,
+		"soon":                          0,
+	} {
+		h := http.Header{}
+		if value != "" {
+			h.Set("Retry-After", value)
+		}
+		if d := parseRetryAfter(h, now); d != expected {
+			t.Errorf("%q: expected %v, got %v", value, expected, d)
+		}
+	}
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+
+		switch n {
+		case 1:
+			// Reset the connection without response
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"params": {}, "iocs": [], "has_more": false}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(
+		WithAPIURL(srv.URL+"/"),
+		WithRetryPolicy(&BackoffPolicy{MaxAttempts: 3, Wait: time.Millisecond}),
+		WithPageDelay(0),
+	)
+	if err := c.WriteIOCs("example", "domainname", "", "json", ioutil.Discard); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if requests != 3 {
+		t.Fatalf("expected 3 requests, got %d", requests)
+	}
+
+	requests = 1
+	c = NewClient(WithAPIURL(srv.URL+"/"), WithRetryPolicy(nil), WithPageDelay(0))
+	if err := c.WriteIOCs("example", "domainname", "", "json", ioutil.Discard); !IsRateLimited(err) {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+}
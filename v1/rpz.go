@@ -0,0 +1,153 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// RPZ policy actions
+const (
+	RPZActionNXDomain = "CNAME ."
+	RPZActionNoData   = "CNAME *."
+	RPZActionDrop     = "CNAME rpz-drop."
+	RPZActionPassthru = "CNAME rpz-passthru."
+)
+
+// ParseRPZAction returns the policy action named by action (nxdomain,
+// nodata, drop or passthru). Any other value is returned unchanged, so
+// records like "CNAME walled-garden.example.com." or "A 192.0.2.1" can be
+// used as local data actions.
+func ParseRPZAction(action string) string {
+	switch strings.ToLower(action) {
+	case "", "nxdomain":
+		return RPZActionNXDomain
+	case "nodata":
+		return RPZActionNoData
+	case "drop":
+		return RPZActionDrop
+	case "passthru":
+		return RPZActionPassthru
+	default:
+		return action
+	}
+}
+
+// rpzName returns the zone relative owner name of domain, or false if it is
+// not a valid domain name
+func rpzName(domain string) (string, bool) {
+	name := strings.ToLower(strings.TrimSuffix(domain, "."))
+	if name == "" || len(name) > 253 {
+		return "", false
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return "", false
+		}
+		for _, c := range []byte(label) {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return "", false
+			}
+		}
+	}
+
+	return name, true
+}
+
+// RPZWriter writes DomainName IOCs as a DNS Response Policy Zone. Owner
+// names are relative to the zone origin configured in the resolver. Other
+// data types are skipped, as are duplicate domains.
+type RPZWriter struct {
+	// TTL is the default TTL of the zone
+	TTL int
+	// Serial is the SOA serial, by default the creation time of the writer
+	// in seconds since the epoch
+	Serial uint32
+	// MName and RName are the primary name server and the responsible
+	// mailbox of the SOA record, NS the name server of the zone
+	MName, RName, NS string
+	// Action is the policy action of the records, e.g. RPZActionNXDomain
+	Action string
+	// Wildcard adds a record for all subdomains of every domain
+	Wildcard bool
+	// Skipped counts the IOCs which could not be converted
+	Skipped int
+	// Logger, if set, is told about each skipped IOC
+	Logger *log.Logger
+
+	w       io.Writer
+	started bool
+	seen    map[string]bool
+}
+
+func NewRPZWriter(w io.Writer) *RPZWriter {
+	return &RPZWriter{
+		TTL:    300,
+		Serial: uint32(time.Now().Unix()),
+		MName:  "localhost.",
+		RName:  "hostmaster.localhost.",
+		NS:     "localhost.",
+		Action: RPZActionNXDomain,
+		w:      w,
+		seen:   make(map[string]bool),
+	}
+}
+
+// SetLogger implements LoggerSetter
+func (zw *RPZWriter) SetLogger(l *log.Logger) {
+	zw.Logger = l
+}
+
+func (zw *RPZWriter) begin() error {
+	zw.started = true
+	_, err := fmt.Fprintf(zw.w, "$TTL %d\n@ IN SOA %s %s %d 3600 600 86400 %d\n  IN NS %s\n",
+		zw.TTL, zw.MName, zw.RName, zw.Serial, zw.TTL, zw.NS)
+	return err
+}
+
+func (zw *RPZWriter) WriteIOC(ioc *IOC) error {
+	name, ok := "", false
+	if dataType, _ := CanonicalDataType(ioc.DataType); dataType == DataTypeDomainName {
+		name, ok = rpzName(ioc.Value)
+	}
+	if !ok {
+		logSkipped(zw.Logger, "RPZ", ioc)
+		zw.Skipped++
+		return nil
+	}
+
+	if !zw.started {
+		if err := zw.begin(); err != nil {
+			return err
+		}
+	}
+
+	if zw.seen[name] {
+		return nil
+	}
+	zw.seen[name] = true
+
+	if _, err := fmt.Fprintf(zw.w, "%s %s\n", name, zw.Action); err != nil {
+		return err
+	}
+	if zw.Wildcard {
+		if _, err := fmt.Fprintf(zw.w, "*.%s %s\n", name, zw.Action); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (zw *RPZWriter) Close() error {
+	if !zw.started {
+		return zw.begin()
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRPZWriter(t *testing.T) {
+	iocs := []IOC{
+		{DataType: "DomainName", Value: "Evil.example.com."},
+		{DataType: "DomainName", Value: "evil.example.com"},
+		{DataType: "DomainName", Value: "bad name.example.com"},
+		{DataType: "IPv4", Value: "192.0.2.1"},
+		{DataType: "domainname", Value: "phish.example.org"},
+	}
+
+	var out bytes.Buffer
+	zw := NewRPZWriter(&out)
+	zw.Serial = 1520000000
+	zw.Action = ParseRPZAction("drop")
+	zw.Wildcard = true
+	for i := range iocs {
+		if err := zw.WriteIOC(&iocs[i]); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if zw.Skipped != 2 {
+		t.Errorf("expected 2 skipped IOCs, got %v", zw.Skipped)
+	}
+
+	expected := "$TTL 300\n" +
+		"@ IN SOA localhost. hostmaster.localhost. 1520000000 3600 600 86400 300\n" +
+		"  IN NS localhost.\n" +
+		"evil.example.com CNAME rpz-drop.\n" +
+		"*.evil.example.com CNAME rpz-drop.\n" +
+		"phish.example.org CNAME rpz-drop.\n" +
+		"*.phish.example.org CNAME rpz-drop.\n"
+	if out.String() != expected {
+		t.Errorf("unexpected zone:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestParseRPZAction(t *testing.T) {
+	for action, expected := range map[string]string{
+		"":              RPZActionNXDomain,
+		"NODATA":        RPZActionNoData,
+		"passthru":      RPZActionPassthru,
+		"A 192.0.2.53":  "A 192.0.2.53",
+		"CNAME garden.": "CNAME garden.",
+	} {
+		if got := ParseRPZAction(action); got != expected {
+			t.Errorf("expected %q for %q, got %q", expected, action, got)
+		}
+	}
+}
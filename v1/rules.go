@@ -0,0 +1,287 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Rule dialects supported by RuleWriter
+const (
+	RuleDialectSuricata = "suricata"
+	RuleDialectSnort    = "snort"
+)
+
+// DefaultClasstypes maps TIE categories to the classtypes of the default
+// Suricata and Snort classification.config
+var DefaultClasstypes = map[string]string{
+	"apt":        "targeted-activity",
+	"botnet":     "trojan-activity",
+	"c2server":   "trojan-activity",
+	"exploitkit": "web-application-attack",
+	"malware":    "trojan-activity",
+	"phishing":   "social-engineering",
+	"ransomware": "trojan-activity",
+	"spam":       "policy-violation",
+}
+
+// RuleWriter turns IOCs into Suricata or Snort rules. DomainName, URL, IP
+// address and, for Suricata, file hash IOCs are supported; other data types
+// are skipped.
+//
+// IP addresses are matched by one rule per address, unless IPRep is set.
+// File hashes can only be matched through the filemd5 and filesha256
+// keywords, which reference hash list files, so hash IOCs are skipped
+// unless MD5List or SHA256List are set. The rules referencing the lists
+// are written on Close.
+type RuleWriter struct {
+	// Dialect is RuleDialectSuricata (the default) or RuleDialectSnort
+	Dialect string
+	// SIDBase is the SID of the first rule, further rules are numbered
+	// consecutively
+	SIDBase int
+	// Classtypes maps TIE categories to rule classtypes. The classtype of
+	// the first mapped category of an IOC is used, or DefaultClasstype if
+	// there is none.
+	Classtypes       map[string]string
+	DefaultClasstype string
+
+	// IPRep receives IP addresses in Suricata's IP reputation list format,
+	// with the maximum severity scaled to a reputation score. For Snort,
+	// one address per line is written for the reputation preprocessor.
+	IPRep io.Writer
+	// IPRepCategory is the category name and number configured in
+	// Suricata's reputation categories file
+	IPRepCategory       string
+	IPRepCategoryNumber int
+
+	// MD5List and SHA256List receive hash lists for the filemd5 and
+	// filesha256 keywords. MD5ListName and SHA256ListName are the file
+	// names referenced by the rules.
+	MD5List        io.Writer
+	MD5ListName    string
+	SHA256List     io.Writer
+	SHA256ListName string
+
+	// Skipped counts the IOCs for which no rule could be generated
+	Skipped int
+	// Logger, if set, is told about each skipped IOC
+	Logger *log.Logger
+
+	w      io.Writer
+	sid    int
+	iprep  bool
+	md5    bool
+	sha256 bool
+}
+
+func NewRuleWriter(w io.Writer) *RuleWriter {
+	return &RuleWriter{
+		Dialect:             RuleDialectSuricata,
+		SIDBase:             1000000,
+		Classtypes:          DefaultClasstypes,
+		DefaultClasstype:    "bad-unknown",
+		IPRepCategory:       "TIE",
+		IPRepCategoryNumber: 1,
+		MD5ListName:         "tie-md5.list",
+		SHA256ListName:      "tie-sha256.list",
+		w:                   w,
+	}
+}
+
+// SetLogger implements LoggerSetter
+func (rw *RuleWriter) SetLogger(l *log.Logger) {
+	rw.Logger = l
+}
+
+// ruleContent escapes s for use in a content match
+func ruleContent(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch c {
+		case '"', ';', '\\', '|':
+			fmt.Fprintf(&b, "|%02X|", c)
+		default:
+			if c < 0x20 || c > 0x7e {
+				fmt.Fprintf(&b, "|%02X|", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// ruleMsg escapes s for use in a msg option
+func ruleMsg(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `;`, `\;`, "\n", " ", "\r", " ")
+	return r.Replace(s)
+}
+
+// dnsWireName encodes a domain name as a sequence of DNS labels for Snort
+// content matches
+func dnsWireName(domain string) string {
+	var b strings.Builder
+	for _, label := range strings.Split(strings.TrimSuffix(domain, "."), ".") {
+		fmt.Fprintf(&b, "|%02X|%s", len(label), ruleContent(label))
+	}
+	b.WriteString("|00|")
+	return b.String()
+}
+
+func (rw *RuleWriter) classtype(ioc *IOC) string {
+	for _, category := range ioc.Categories {
+		if classtype, ok := rw.Classtypes[strings.ToLower(category)]; ok {
+			return classtype
+		}
+	}
+	return rw.DefaultClasstype
+}
+
+// writeRule writes a rule with the given header and options, adding msg,
+// classtype, sid, rev and metadata
+func (rw *RuleWriter) writeRule(header, msg string, options []string, classtype string, metadata ...string) error {
+	opts := []string{`msg:"` + ruleMsg(msg) + `"`}
+	opts = append(opts, options...)
+	opts = append(opts,
+		"classtype:"+classtype,
+		"sid:"+strconv.Itoa(rw.SIDBase+rw.sid),
+		"rev:1",
+	)
+	if len(metadata) > 0 {
+		opts = append(opts, "metadata:"+strings.Join(metadata, ", "))
+	}
+	rw.sid++
+
+	_, err := fmt.Fprintf(rw.w, "%s (%s;)\n", header, strings.Join(opts, "; "))
+	return err
+}
+
+func (rw *RuleWriter) skip(ioc *IOC) error {
+	logSkipped(rw.Logger, "rules", ioc)
+	rw.Skipped++
+	return nil
+}
+
+func (rw *RuleWriter) WriteIOC(ioc *IOC) error {
+	dataType, _ := CanonicalDataType(ioc.DataType)
+	snort := rw.Dialect == RuleDialectSnort
+
+	msg := "DCSO TIE " + dataType + " " + ioc.Value
+	classtype := rw.classtype(ioc)
+	metadata := []string{
+		"tie_severity " + strconv.Itoa(ioc.MaxSeverity),
+		"tie_confidence " + strconv.Itoa(ioc.MaxConfidence),
+	}
+	if ioc.ID != "" && !strings.ContainsAny(ioc.ID, ",; ") {
+		metadata = append([]string{"tie_id " + ioc.ID}, metadata...)
+	}
+
+	switch dataType {
+	case DataTypeDomainName:
+		domain := strings.ToLower(strings.TrimSuffix(ioc.Value, "."))
+		if snort {
+			return rw.writeRule("alert udp $HOME_NET any -> any 53", msg, []string{
+				`content:"` + dnsWireName(domain) + `"`, "nocase", "fast_pattern",
+			}, classtype, metadata...)
+		}
+		return rw.writeRule("alert dns $HOME_NET any -> any any", msg, []string{
+			"dns.query", "dotprefix", `content:".` + ruleContent(domain) + `"`, "nocase", "endswith",
+		}, classtype, metadata...)
+
+	case DataTypeURLVerbatim:
+		u, err := url.Parse(ioc.Value)
+		if err != nil || u.Hostname() == "" {
+			return rw.skip(ioc)
+		}
+		host := strings.ToLower(u.Hostname())
+		uri := u.RequestURI()
+		if snort {
+			options := []string{"flow:established,to_server",
+				`content:"` + ruleContent(host) + `"`, "http_header", "nocase"}
+			if uri != "/" {
+				options = append(options, `content:"`+ruleContent(uri)+`"`, "http_uri")
+			}
+			return rw.writeRule("alert tcp $HOME_NET any -> $EXTERNAL_NET $HTTP_PORTS", msg,
+				options, classtype, metadata...)
+		}
+		options := []string{"flow:established,to_server",
+			"http.host", `content:"` + ruleContent(host) + `"`, "bsize:" + strconv.Itoa(len(host))}
+		if uri != "/" {
+			options = append(options, "http.uri", `content:"`+ruleContent(uri)+`"`, "startswith")
+		}
+		return rw.writeRule("alert http $HOME_NET any -> $EXTERNAL_NET any", msg,
+			options, classtype, metadata...)
+
+	case DataTypeIPv4, DataTypeIPv6:
+		if net.ParseIP(ioc.Value) == nil {
+			return rw.skip(ioc)
+		}
+		if rw.IPRep != nil {
+			rw.iprep = true
+			if snort {
+				_, err := fmt.Fprintln(rw.IPRep, ioc.Value)
+				return err
+			}
+			// Reputation scores range from 0 to 127
+			score := ioc.MaxSeverity * 127 / MaxSeverity
+			_, err := fmt.Fprintf(rw.IPRep, "%s,%d,%d\n", ioc.Value, rw.IPRepCategoryNumber, score)
+			return err
+		}
+		return rw.writeRule("alert ip $HOME_NET any -> ["+ioc.Value+"] any", msg,
+			nil, classtype, metadata...)
+
+	case DataTypeExactHash:
+		if snort {
+			return rw.skip(ioc)
+		}
+		switch algorithm, digest := HashAlgorithm(ioc.Value); {
+		case algorithm == HashMD5 && rw.MD5List != nil:
+			rw.md5 = true
+			_, err := fmt.Fprintln(rw.MD5List, digest)
+			return err
+		case algorithm == HashSHA256 && rw.SHA256List != nil:
+			rw.sha256 = true
+			_, err := fmt.Fprintln(rw.SHA256List, digest)
+			return err
+		}
+	}
+
+	return rw.skip(ioc)
+}
+
+func (rw *RuleWriter) Close() error {
+	if rw.iprep && rw.Dialect != RuleDialectSnort {
+		err := rw.writeRule("alert ip $HOME_NET any -> any any", "DCSO TIE IP reputation", []string{
+			"iprep:dst," + rw.IPRepCategory + ",>,0",
+		}, rw.DefaultClasstype)
+		if err != nil {
+			return err
+		}
+	}
+	if rw.md5 {
+		err := rw.writeRule("alert http any any -> any any", "DCSO TIE file MD5", []string{
+			"filemd5:" + rw.MD5ListName,
+		}, rw.DefaultClasstype)
+		if err != nil {
+			return err
+		}
+	}
+	if rw.sha256 {
+		err := rw.writeRule("alert http any any -> any any", "DCSO TIE file SHA-256", []string{
+			"filesha256:" + rw.SHA256ListName,
+		}, rw.DefaultClasstype)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var ruleTestIOCs = []IOC{
+	{ID: "ioc-1", DataType: "DomainName", Value: "Evil.example.com", Categories: []string{"c2server"}, MaxSeverity: 4, MaxConfidence: 80},
+	{ID: "ioc-2", DataType: "URLVerbatim", Value: "http://bad.example.org/x;y.php?a=1", Categories: []string{"phishing"}},
+	{ID: "ioc-3", DataType: "IPv4", Value: "192.0.2.1", MaxSeverity: 5},
+	{ID: "ioc-4", DataType: "ExactHash", Value: "md5:" + strings.Repeat("ab", 16)},
+	{ID: "ioc-5", DataType: "Mutex", Value: "x"},
+}
+
+func writeRules(t *testing.T, rw *RuleWriter) {
+	for i := range ruleTestIOCs {
+		if err := rw.WriteIOC(&ruleTestIOCs[i]); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestRuleWriterSuricata(t *testing.T) {
+	var out, iprep, md5 bytes.Buffer
+	rw := NewRuleWriter(&out)
+	rw.SIDBase = 5000
+	rw.IPRep = &iprep
+	rw.MD5List = &md5
+	writeRules(t, rw)
+
+	if rw.Skipped != 1 {
+		t.Errorf("expected 1 skipped IOC, got %v", rw.Skipped)
+	}
+
+	rules := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(rules) != 4 {
+		t.Fatalf("expected 4 rules, got %q", out.String())
+	}
+	for i, rule := range rules {
+		if !strings.HasPrefix(rule, "alert ") || !strings.HasSuffix(rule, ";)") {
+			t.Errorf("malformed rule %q", rule)
+		}
+		if !strings.Contains(rule, fmt.Sprintf("sid:%d;", 5000+i)) {
+			t.Errorf("rule %d has unexpected SID: %q", i, rule)
+		}
+	}
+
+	for _, expected := range []string{
+		`dns.query; dotprefix; content:".evil.example.com"; nocase; endswith;`,
+		"classtype:trojan-activity;",
+		"metadata:tie_id ioc-1, tie_severity 4, tie_confidence 80;",
+	} {
+		if !strings.Contains(rules[0], expected) {
+			t.Errorf("domain rule %q lacks %q", rules[0], expected)
+		}
+	}
+	for _, expected := range []string{
+		`http.host; content:"bad.example.org"; bsize:15;`,
+		`http.uri; content:"/x|3B|y.php?a=1"; startswith;`,
+		"classtype:social-engineering;",
+	} {
+		if !strings.Contains(rules[1], expected) {
+			t.Errorf("URL rule %q lacks %q", rules[1], expected)
+		}
+	}
+	if !strings.Contains(rules[2], "iprep:dst,TIE,>,0;") {
+		t.Errorf("unexpected IP reputation rule %q", rules[2])
+	}
+	if !strings.Contains(rules[3], "filemd5:tie-md5.list;") {
+		t.Errorf("unexpected MD5 rule %q", rules[3])
+	}
+
+	if iprep.String() != "192.0.2.1,1,127\n" {
+		t.Errorf("unexpected IP reputation list %q", iprep.String())
+	}
+	if md5.String() != strings.Repeat("ab", 16)+"\n" {
+		t.Errorf("unexpected MD5 list %q", md5.String())
+	}
+}
+
+func TestRuleWriterSnort(t *testing.T) {
+	var out bytes.Buffer
+	rw := NewRuleWriter(&out)
+	rw.Dialect = RuleDialectSnort
+	writeRules(t, rw)
+
+	if rw.Skipped != 2 {
+		t.Errorf("expected 2 skipped IOCs, got %v", rw.Skipped)
+	}
+
+	rules := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(rules) != 3 {
+		t.Fatalf("expected 3 rules, got %q", out.String())
+	}
+	if !strings.Contains(rules[0], `content:"|04|evil|07|example|03|com|00|"`) {
+		t.Errorf("unexpected domain rule %q", rules[0])
+	}
+	if !strings.Contains(rules[1], `content:"bad.example.org"; http_header;`) {
+		t.Errorf("unexpected URL rule %q", rules[1])
+	}
+	if !strings.HasPrefix(rules[2], "alert ip $HOME_NET any -> [192.0.2.1] any") ||
+		!strings.Contains(rules[2], "sid:1000002;") {
+		t.Errorf("unexpected IP rule %q", rules[2])
+	}
+}
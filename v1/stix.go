@@ -0,0 +1,217 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// stixListSections are the STIX_Package children whose entries are collected
+// from all pages. All other sections, e.g. the STIX_Header, are taken from
+// the first page they appear on.
+var stixListSections = map[string]bool{
+	"Observables":       true,
+	"Indicators":        true,
+	"TTPs":              true,
+	"Exploit_Targets":   true,
+	"Incidents":         true,
+	"Courses_Of_Action": true,
+	"Campaigns":         true,
+	"Threat_Actors":     true,
+	"Reports":           true,
+	"Related_Packages":  true,
+}
+
+type stixSection struct {
+	start   xml.StartElement
+	entries [][]byte
+}
+
+// STIXPageAggregator merges the STIX 1.x packages of all result pages into a
+// single package. Observables, indicators and the other list sections of
+// every page are combined, while the package header and attributes are
+// taken from the first page. Entries are copied verbatim, so namespace
+// prefixes declared on the package element of any page are kept on the
+// merged package element.
+type STIXPageAggregator struct {
+	prolog   []byte
+	root     *xml.StartElement
+	sections []*stixSection
+}
+
+func (pa *STIXPageAggregator) section(start xml.StartElement) *stixSection {
+	for _, s := range pa.sections {
+		if s.start.Name == start.Name {
+			return s
+		}
+	}
+
+	s := &stixSection{start: start}
+	pa.sections = append(pa.sections, s)
+	return s
+}
+
+func (pa *STIXPageAggregator) addRoot(start xml.StartElement) error {
+	if pa.root == nil {
+		pa.root = &start
+		return nil
+	}
+
+	if pa.root.Name != start.Name {
+		return fmt.Errorf("unexpected STIX root element %v:%v", start.Name.Space, start.Name.Local)
+	}
+
+	// Keep namespace declarations of all pages
+	for _, attr := range start.Attr {
+		if attr.Name.Space != "xmlns" && !(attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			continue
+		}
+		known := false
+		for _, rootAttr := range pa.root.Attr {
+			if rootAttr.Name == attr.Name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			pa.root.Attr = append(pa.root.Attr, attr)
+		}
+	}
+
+	return nil
+}
+
+func (pa *STIXPageAggregator) AddPage(reader io.Reader) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	var (
+		depth      int
+		current    *stixSection
+		isNew      bool
+		entryStart int64
+		seenRoot   bool
+	)
+
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("parse STIX page: %v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.ProcInst:
+			if depth == 0 && pa.prolog == nil {
+				pa.prolog = append([]byte(nil), data[offset:dec.InputOffset()]...)
+			}
+		case xml.StartElement:
+			switch depth {
+			case 0:
+				if seenRoot {
+					return errors.New("parse STIX page: multiple root elements")
+				}
+				seenRoot = true
+				if err := pa.addRoot(t.Copy()); err != nil {
+					return err
+				}
+			case 1:
+				n := len(pa.sections)
+				current = pa.section(t.Copy())
+				isNew = len(pa.sections) > n
+			case 2:
+				entryStart = offset
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 2 && current != nil && (isNew || stixListSections[current.start.Name.Local]) {
+				current.entries = append(current.entries, append([]byte(nil), data[entryStart:dec.InputOffset()]...))
+			}
+			if depth == 1 {
+				current = nil
+			}
+		}
+	}
+
+	if !seenRoot {
+		return errors.New("parse STIX page: no STIX package found")
+	} else if depth != 0 {
+		return errors.New("parse STIX page: unexpected end of document")
+	}
+
+	return nil
+}
+
+func writeStartElement(w io.Writer, start xml.StartElement) error {
+	var buf bytes.Buffer
+
+	buf.WriteString("<" + rawName(start.Name))
+	for _, attr := range start.Attr {
+		buf.WriteString(" " + rawName(attr.Name) + `="`)
+		if err := xml.EscapeText(&buf, []byte(attr.Value)); err != nil {
+			return err
+		}
+		buf.WriteString(`"`)
+	}
+	buf.WriteString(">")
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// rawName formats names as returned by xml.Decoder.RawToken, which keeps the
+// namespace prefix in Space
+func rawName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+func (pa *STIXPageAggregator) Finish(writer io.Writer) error {
+	if pa.root == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+
+	if pa.prolog != nil {
+		buf.Write(pa.prolog)
+		buf.WriteString("\n")
+	}
+	if err := writeStartElement(&buf, *pa.root); err != nil {
+		return err
+	}
+	for _, s := range pa.sections {
+		buf.WriteString("\n  ")
+		if err := writeStartElement(&buf, s.start); err != nil {
+			return err
+		}
+		for _, entry := range s.entries {
+			buf.WriteString("\n    ")
+			buf.Write(entry)
+		}
+		buf.WriteString("\n  </" + rawName(s.start.Name) + ">")
+	}
+	buf.WriteString("\n</" + rawName(pa.root.Name) + ">\n")
+
+	_, err := buf.WriteTo(writer)
+	return err
+}
+
+func (pa *STIXPageAggregator) Reset() {
+	*pa = STIXPageAggregator{}
+}
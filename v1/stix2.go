@@ -0,0 +1,255 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// iocNamespace is the UUIDv5 namespace for the IDs of objects derived from
+// TIE IOCs, so the same IOC always maps to the same ID.
+var iocNamespace = [16]byte{
+	0x0f, 0x3c, 0x5e, 0x2a, 0x7d, 0x41, 0x4b, 0x2e,
+	0x9a, 0x1c, 0x6d, 0x3f, 0x52, 0xe8, 0xb4, 0x07,
+}
+
+const stix2TimeFormat = "2006-01-02T15:04:05.000Z"
+
+func formatUUID(u []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// uuid5 returns the name based UUID of name in namespace ns
+func uuid5(ns [16]byte, name string) string {
+	h := sha1.New()
+	h.Write(ns[:])
+	h.Write([]byte(name))
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return formatUUID(u)
+}
+
+// iocUUID returns the UUID identifying ioc in locally generated formats
+func iocUUID(ioc *IOC) string {
+	name := ioc.ID
+	if name == "" {
+		name = ioc.DataType + ":" + ioc.Value
+	}
+	return uuid5(iocNamespace, name)
+}
+
+// uuid4 returns a random UUID
+func uuid4() (string, error) {
+	u := make([]byte, 16)
+	if _, err := rand.Read(u); err != nil {
+		return "", err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return formatUUID(u), nil
+}
+
+// STIX2ExternalReference is a STIX 2.1 external reference
+type STIX2ExternalReference struct {
+	SourceName string `json:"source_name"`
+	ExternalID string `json:"external_id,omitempty"`
+}
+
+// STIX2Indicator is a STIX 2.1 indicator object
+type STIX2Indicator struct {
+	Type               string                   `json:"type"`
+	SpecVersion        string                   `json:"spec_version"`
+	ID                 string                   `json:"id"`
+	Created            string                   `json:"created"`
+	Modified           string                   `json:"modified"`
+	Name               string                   `json:"name"`
+	IndicatorTypes     []string                 `json:"indicator_types"`
+	Pattern            string                   `json:"pattern"`
+	PatternType        string                   `json:"pattern_type"`
+	ValidFrom          string                   `json:"valid_from"`
+	Confidence         int                      `json:"confidence"`
+	Labels             []string                 `json:"labels,omitempty"`
+	ExternalReferences []STIX2ExternalReference `json:"external_references,omitempty"`
+}
+
+// stix2String quotes s as a STIX pattern string literal
+func stix2String(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `'`, `\'`, -1)
+	return "'" + s + "'"
+}
+
+// STIX2Pattern returns the STIX 2.1 pattern matching the value of ioc. It
+// returns false for data types without a STIX 2.1 representation.
+func STIX2Pattern(ioc *IOC) (string, bool) {
+	dataType, _ := CanonicalDataType(ioc.DataType)
+
+	var expr string
+	switch dataType {
+	case DataTypeDomainName:
+		expr = "domain-name:value = " + stix2String(ioc.Value)
+	case DataTypeURLVerbatim:
+		expr = "url:value = " + stix2String(ioc.Value)
+	case DataTypeIPv4:
+		expr = "ipv4-addr:value = " + stix2String(ioc.Value)
+	case DataTypeIPv6:
+		expr = "ipv6-addr:value = " + stix2String(ioc.Value)
+	case DataTypeEmail:
+		expr = "email-addr:value = " + stix2String(ioc.Value)
+	case DataTypeExactHash:
+		algorithm, digest := HashAlgorithm(ioc.Value)
+		if algorithm == "" {
+			return "", false
+		}
+		expr = "file:hashes." + stix2String(algorithm) + " = " + stix2String(digest)
+	case DataTypeFileName:
+		expr = "file:name = " + stix2String(ioc.Value)
+	case DataTypeMutex:
+		expr = "mutex:name = " + stix2String(ioc.Value)
+	case DataTypeRegistryKey:
+		expr = "windows-registry-key:key = " + stix2String(ioc.Value)
+	case DataTypeUserAgent:
+		expr = "network-traffic:extensions.'http-request-ext'.request_header.'User-Agent' = " + stix2String(ioc.Value)
+	case DataTypeASN:
+		number := strings.TrimPrefix(strings.ToUpper(ioc.Value), "AS")
+		for _, c := range number {
+			if c < '0' || c > '9' {
+				return "", false
+			}
+		}
+		if number == "" {
+			return "", false
+		}
+		expr = "autonomous-system:number = " + number
+	default:
+		return "", false
+	}
+
+	return "[" + expr + "]", true
+}
+
+func stix2Time(t *time.Time, fallback time.Time) string {
+	if t == nil {
+		return fallback.UTC().Format(stix2TimeFormat)
+	}
+	return t.UTC().Format(stix2TimeFormat)
+}
+
+// NewSTIX2Indicator converts ioc into a STIX 2.1 indicator. The confidence
+// is the mean of the IOC's minimum and maximum confidence, the labels are
+// its categories. It returns false for data types without a STIX 2.1
+// pattern.
+func NewSTIX2Indicator(ioc *IOC) (*STIX2Indicator, bool) {
+	pattern, ok := STIX2Pattern(ioc)
+	if !ok {
+		return nil, false
+	}
+
+	now := time.Now()
+	created := stix2Time(ioc.CreatedAt, now)
+
+	indicator := &STIX2Indicator{
+		Type:           "indicator",
+		SpecVersion:    "2.1",
+		ID:             "indicator--" + iocUUID(ioc),
+		Created:        created,
+		Modified:       stix2Time(ioc.UpdatedAt, now),
+		Name:           ioc.Value,
+		IndicatorTypes: []string{"malicious-activity"},
+		Pattern:        pattern,
+		PatternType:    "stix",
+		ValidFrom:      stix2Time(ioc.FirstSeen, now),
+		Confidence:     (ioc.MinConfidence + ioc.MaxConfidence) / 2,
+		Labels:         ioc.Categories,
+	}
+
+	if ioc.FirstSeen == nil && ioc.CreatedAt != nil {
+		indicator.ValidFrom = created
+	}
+
+	if ioc.ID != "" {
+		indicator.ExternalReferences = []STIX2ExternalReference{
+			{SourceName: "DCSO TIE", ExternalID: ioc.ID},
+		}
+	}
+
+	return indicator, true
+}
+
+// STIX2Writer writes IOCs as indicators of a STIX 2.1 bundle. The bundle
+// is streamed, so the IOCs are written as they are passed in.
+type STIX2Writer struct {
+	w        io.Writer
+	started  bool
+	bundleID string
+	// Skipped counts the IOCs which could not be converted
+	Skipped int
+	// Logger, if set, is told about each skipped IOC
+	Logger *log.Logger
+}
+
+func NewSTIX2Writer(w io.Writer) *STIX2Writer {
+	return &STIX2Writer{w: w}
+}
+
+// SetLogger implements LoggerSetter
+func (sw *STIX2Writer) SetLogger(l *log.Logger) {
+	sw.Logger = l
+}
+
+func (sw *STIX2Writer) begin() error {
+	id, err := uuid4()
+	if err != nil {
+		return err
+	}
+	sw.bundleID = "bundle--" + id
+	sw.started = true
+
+	_, err = fmt.Fprintf(sw.w, `{"type":"bundle","id":%q,"objects":[`, sw.bundleID)
+	return err
+}
+
+func (sw *STIX2Writer) WriteIOC(ioc *IOC) error {
+	indicator, ok := NewSTIX2Indicator(ioc)
+	if !ok {
+		logSkipped(sw.Logger, "STIX 2.1", ioc)
+		sw.Skipped++
+		return nil
+	}
+
+	data, err := json.Marshal(indicator)
+	if err != nil {
+		return err
+	}
+
+	if !sw.started {
+		if err := sw.begin(); err != nil {
+			return err
+		}
+	} else if _, err := io.WriteString(sw.w, ","); err != nil {
+		return err
+	}
+
+	_, err = sw.w.Write(data)
+	return err
+}
+
+func (sw *STIX2Writer) Close() error {
+	if !sw.started {
+		if err := sw.begin(); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(sw.w, "]}\n")
+	return err
+}
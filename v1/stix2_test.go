@@ -0,0 +1,111 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSTIX2Pattern(t *testing.T) {
+	for _, tc := range []struct {
+		dataType, value, pattern string
+	}{
+		{"domainname", "evil.example.com", "[domain-name:value = 'evil.example.com']"},
+		{"URLVerbatim", "http://example.com/it's", `[url:value = 'http://example.com/it\'s']`},
+		{"IPv4", "192.0.2.1", "[ipv4-addr:value = '192.0.2.1']"},
+		{"ExactHash", "sha256:" + strings.Repeat("AB", 32), "[file:hashes.'SHA-256' = '" + strings.Repeat("ab", 32) + "']"},
+		{"ExactHash", strings.Repeat("0", 32), "[file:hashes.'MD5' = '" + strings.Repeat("0", 32) + "']"},
+		{"ASN", "AS3320", "[autonomous-system:number = 3320]"},
+	} {
+		pattern, ok := STIX2Pattern(&IOC{DataType: tc.dataType, Value: tc.value})
+		if !ok || pattern != tc.pattern {
+			t.Errorf("%v %v: expected %q, got %q", tc.dataType, tc.value, tc.pattern, pattern)
+		}
+	}
+
+	for _, ioc := range []IOC{
+		{DataType: "CVE", Value: "CVE-2017-0144"},
+		{DataType: "ExactHash", Value: "xyz"},
+		{DataType: "ASN", Value: "ASX"},
+	} {
+		if pattern, ok := STIX2Pattern(&ioc); ok {
+			t.Errorf("%v %v: expected no pattern, got %q", ioc.DataType, ioc.Value, pattern)
+		}
+	}
+}
+
+func TestSTIX2Writer(t *testing.T) {
+	firstSeen := time.Date(2018, 2, 1, 8, 30, 0, 0, time.UTC)
+	page := IOCQueryStruct{
+		Iocs: []IOC{
+			{
+				ID:            "ioc-1",
+				DataType:      "DomainName",
+				Value:         "evil.example.com",
+				Categories:    []string{"c2server"},
+				MinConfidence: 60,
+				MaxConfidence: 80,
+				FirstSeen:     &firstSeen,
+			},
+			{ID: "ioc-2", DataType: "CVE", Value: "CVE-2017-0144"},
+		},
+	}
+	data, err := json.Marshal(&page)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var out bytes.Buffer
+	agg := STIX2.StreamAggregator(&out)
+	if err := agg.AddPage(bytes.NewBuffer(data)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := agg.Finish(&out); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var bundle struct {
+		Type    string
+		ID      string
+		Objects []STIX2Indicator
+	}
+	if err := json.Unmarshal(out.Bytes(), &bundle); err != nil {
+		t.Fatalf("invalid bundle: %v", err)
+	}
+
+	if bundle.Type != "bundle" || !strings.HasPrefix(bundle.ID, "bundle--") {
+		t.Errorf("unexpected bundle %v %v", bundle.Type, bundle.ID)
+	}
+	if len(bundle.Objects) != 1 {
+		t.Fatalf("expected 1 indicator, got %v", len(bundle.Objects))
+	}
+
+	indicator := bundle.Objects[0]
+	expected, _ := NewSTIX2Indicator(&page.Iocs[0])
+	if indicator.ID != expected.ID || !strings.HasPrefix(indicator.ID, "indicator--") {
+		t.Errorf("indicator ID %v is not deterministic", indicator.ID)
+	}
+	if indicator.Confidence != 70 {
+		t.Errorf("expected confidence 70, got %v", indicator.Confidence)
+	}
+	if indicator.ValidFrom != "2018-02-01T08:30:00.000Z" {
+		t.Errorf("unexpected valid_from %v", indicator.ValidFrom)
+	}
+	if len(indicator.Labels) != 1 || indicator.Labels[0] != "c2server" {
+		t.Errorf("unexpected labels %v", indicator.Labels)
+	}
+
+	out.Reset()
+	empty := NewSTIX2Writer(&out)
+	if err := empty.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := json.Unmarshal(out.Bytes(), &bundle); err != nil || len(bundle.Objects) != 0 {
+		t.Errorf("invalid empty bundle %q", out.String())
+	}
+}
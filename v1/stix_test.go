@@ -0,0 +1,94 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// stixElementCounts counts the elements of a well-formed STIX document by
+// local name
+func stixElementCounts(t *testing.T, data []byte) map[string]int {
+	counts := map[string]int{}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("invalid XML: %v", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			counts[start.Name.Local]++
+			if start.Name.Space == "" {
+				t.Errorf("unresolved namespace for %v", start.Name.Local)
+			}
+		}
+	}
+
+	return counts
+}
+
+func TestSTIXPageAggregator(t *testing.T) {
+	pages, err := filepath.Glob("testdata/stix/page-*.xml")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(pages) != 2 {
+		t.Fatalf("expected 2 fixture pages, got %v", len(pages))
+	}
+
+	agg := &STIXPageAggregator{}
+	for _, page := range pages {
+		f, err := os.Open(page)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		err = agg.AddPage(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := agg.Finish(&out); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	counts := stixElementCounts(t, out.Bytes())
+	for name, expected := range map[string]int{
+		"STIX_Package": 1,
+		"STIX_Header":  1,
+		"Title":        1 + 3,
+		"Observables":  1,
+		"Observable":   3 + 3,
+		"Indicators":   1,
+		"Indicator":    3,
+	} {
+		if counts[name] != expected {
+			t.Errorf("expected %v %v elements, got %v", expected, name, counts[name])
+		}
+	}
+
+	if !bytes.Contains(out.Bytes(), []byte("gate.php?id=1&amp;x=2")) {
+		t.Errorf("entry content was not copied verbatim")
+	}
+
+	agg.Reset()
+	out.Reset()
+	if err := agg.Finish(&out); err != nil || out.Len() != 0 {
+		t.Errorf("expected empty output after reset, got %q (%v)", out.String(), err)
+	}
+
+	if err := agg.AddPage(bytes.NewBufferString("<stix:STIX_Package>")); err == nil {
+		t.Errorf("expected error for truncated page")
+	}
+}
@@ -0,0 +1,114 @@
+package store
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DCSO/gotie/v1"
+)
+
+// Window is a time window. A zero Since or Until leaves the window open on
+// that side.
+type Window struct {
+	Since time.Time
+	Until time.Time
+}
+
+// Contains reports whether t is within the window. A nil time is only
+// within an unrestricted window.
+func (w Window) Contains(t *time.Time) bool {
+	if w.Since.IsZero() && w.Until.IsZero() {
+		return true
+	}
+	if t == nil {
+		return false
+	}
+	return !t.Before(w.Since) && (w.Until.IsZero() || !t.After(w.Until))
+}
+
+// Query selects stored IOCs, mirroring the filters of TIE IOC requests.
+// Zero fields do not restrict the result.
+type Query struct {
+	// Value matches IOCs whose value contains it, case insensitively
+	Value    string
+	DataType string
+	// Severity and Confidence restrict the maximum severity and confidence
+	// of the IOCs
+	Severity   *gotie.Range
+	Confidence *gotie.Range
+	// Categories and SourcePseudonyms match IOCs with any of the given
+	// categories or sources
+	Categories       []string
+	SourcePseudonyms []string
+
+	Created   Window
+	Updated   Window
+	FirstSeen Window
+	LastSeen  Window
+
+	Limit  int
+	Offset int
+}
+
+func (q *Query) validate() error {
+	for _, w := range []struct {
+		name string
+		w    Window
+	}{
+		{"created", q.Created},
+		{"updated", q.Updated},
+		{"first_seen", q.FirstSeen},
+		{"last_seen", q.LastSeen},
+	} {
+		if !w.w.Since.IsZero() && !w.w.Until.IsZero() && w.w.Until.Before(w.w.Since) {
+			return fmt.Errorf("%v_until is before %v_since", w.name, w.name)
+		}
+	}
+	return nil
+}
+
+// containsAny reports whether list contains any of items, ignoring case
+func containsAny(list, items []string) bool {
+	for _, item := range items {
+		for _, s := range list {
+			if strings.EqualFold(s, item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Match reports whether ioc matches the query, ignoring Limit and Offset
+func (q *Query) Match(ioc *gotie.IOC) bool {
+	if q.Value != "" && !strings.Contains(strings.ToLower(ioc.Value), strings.ToLower(q.Value)) {
+		return false
+	}
+	if q.DataType != "" {
+		want, _ := gotie.CanonicalDataType(q.DataType)
+		if got, _ := gotie.CanonicalDataType(ioc.DataType); got != want {
+			return false
+		}
+	}
+	if q.Severity != nil && !q.Severity.Contains(ioc.MaxSeverity) {
+		return false
+	}
+	if q.Confidence != nil && !q.Confidence.Contains(ioc.MaxConfidence) {
+		return false
+	}
+	if len(q.Categories) > 0 && !containsAny(ioc.Categories, q.Categories) {
+		return false
+	}
+	if len(q.SourcePseudonyms) > 0 && !containsAny(ioc.SourcePseudonyms, q.SourcePseudonyms) {
+		return false
+	}
+
+	return q.Created.Contains(ioc.CreatedAt) &&
+		q.Updated.Contains(ioc.UpdatedAt) &&
+		q.FirstSeen.Contains(ioc.FirstSeen) &&
+		q.LastSeen.Contains(ioc.LastSeen)
+}
@@ -0,0 +1,325 @@
+// Package store persists TIE IOCs locally, so they can be queried when the
+// TIE API is not reachable.
+package store
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/DCSO/gotie/v1"
+	"github.com/DCSO/gotie/v1/internal/atomicfile"
+)
+
+// record is a line of the store's log
+type record struct {
+	// Op is "put" or "del"
+	Op  string     `json:"op"`
+	IOC *gotie.IOC `json:"ioc,omitempty"`
+	Key string     `json:"key,omitempty"`
+}
+
+// Store keeps IOCs in an append-only log file of JSON records and indexes
+// them in memory by ID and by data type and normalized value. Every change
+// is appended to the log, so an interrupted write loses at most the last
+// record. Compact rewrites the log with the current IOCs only.
+//
+// Store implements gotie.IOCStore. It is not safe for concurrent use.
+type Store struct {
+	path     string
+	f        *os.File
+	readOnly bool
+	iocs     map[string]*gotie.IOC
+	values   map[string]string
+	garbage  int
+}
+
+// key returns the key identifying ioc
+func key(ioc *gotie.IOC) string {
+	return gotie.IOCKey(ioc)
+}
+
+// valueKey returns the index key of a data type and value
+func valueKey(dataType, value string) string {
+	ioc := gotie.IOC{DataType: dataType, Value: value}
+	dataType, _ = gotie.CanonicalDataType(dataType)
+	return dataType + "\x00" + gotie.NormalizeIOCValue(&ioc)
+}
+
+// ErrReadOnly is returned when changing a store opened by OpenReadOnly
+var ErrReadOnly = errors.New("store is read-only")
+
+// Open opens the store in the log file path, creating it if it does not
+// exist. A truncated last record, as left by a crash, is discarded.
+func Open(path string) (*Store, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return open(path, f, false)
+}
+
+// OpenReadOnly opens the existing store in the log file path for queries.
+// A truncated last record is ignored, but left in place.
+func OpenReadOnly(path string) (*Store, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return open(path, f, true)
+}
+
+func open(path string, f *os.File, readOnly bool) (*Store, error) {
+	s := &Store{
+		path:     path,
+		f:        f,
+		readOnly: readOnly,
+	}
+	if err := s.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// replay builds the index from the log and positions the file for appending
+func (s *Store) replay() error {
+	s.iocs = make(map[string]*gotie.IOC)
+	s.values = make(map[string]string)
+	s.garbage = 0
+
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(s.f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// Drop an incomplete last record
+			if len(bytes.TrimSpace(line)) > 0 && !s.readOnly {
+				if err := s.f.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("%v: corrupt record at offset %d: %v", s.path, offset, err)
+		}
+		s.apply(&rec)
+		offset += int64(len(line))
+	}
+
+	_, err := s.f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// apply applies rec to the index
+func (s *Store) apply(rec *record) {
+	switch rec.Op {
+	case "put":
+		if rec.IOC == nil {
+			return
+		}
+		s.remove(key(rec.IOC))
+		s.iocs[key(rec.IOC)] = rec.IOC
+		s.values[valueKey(rec.IOC.DataType, rec.IOC.Value)] = key(rec.IOC)
+	case "del":
+		s.remove(rec.Key)
+		s.garbage++
+	}
+}
+
+// remove removes the IOC with key k from the index
+func (s *Store) remove(k string) {
+	ioc, ok := s.iocs[k]
+	if !ok {
+		return
+	}
+	delete(s.iocs, k)
+	if vk := valueKey(ioc.DataType, ioc.Value); s.values[vk] == k {
+		delete(s.values, vk)
+	}
+	s.garbage++
+}
+
+// write appends recs to the log and syncs it
+func (s *Store) write(recs []record) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range recs {
+		if err := enc.Encode(&recs[i]); err != nil {
+			return err
+		}
+	}
+
+	offset, err := s.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(buf.Bytes()); err != nil {
+		// Do not leave a partial record in front of later ones
+		if terr := s.f.Truncate(offset); terr == nil {
+			s.f.Seek(offset, io.SeekStart)
+		}
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+
+	for i := range recs {
+		s.apply(&recs[i])
+	}
+	return nil
+}
+
+// Upsert stores the IOCs, replacing stored IOCs with the same ID
+func (s *Store) Upsert(iocs []gotie.IOC) error {
+	recs := make([]record, len(iocs))
+	for i := range iocs {
+		ioc := iocs[i]
+		recs[i] = record{Op: "put", IOC: &ioc}
+	}
+	return s.write(recs)
+}
+
+// Get returns the IOC with the given ID
+func (s *Store) Get(id string) (*gotie.IOC, bool) {
+	ioc, ok := s.iocs[id]
+	return ioc, ok
+}
+
+// Lookup returns the IOC of the given data type and value. The value is
+// normalized as by gotie.NormalizeIOCValue.
+func (s *Store) Lookup(dataType, value string) (*gotie.IOC, bool) {
+	k, ok := s.values[valueKey(dataType, value)]
+	if !ok {
+		return nil, false
+	}
+	return s.Get(k)
+}
+
+// Len returns the number of stored IOCs
+func (s *Store) Len() int {
+	return len(s.iocs)
+}
+
+// Expire removes the IOCs last seen before t, or created before t if they
+// have not been seen, and returns their number.
+func (s *Store) Expire(t time.Time) (int, error) {
+	var recs []record
+	for k, ioc := range s.iocs {
+		seen := ioc.LastSeen
+		if seen == nil {
+			seen = ioc.CreatedAt
+		}
+		if seen != nil && seen.Before(t) {
+			recs = append(recs, record{Op: "del", Key: k})
+		}
+	}
+	if len(recs) == 0 {
+		return 0, nil
+	}
+
+	return len(recs), s.write(recs)
+}
+
+// Query returns the stored IOCs matching q, ordered by ID
+func (s *Store) Query(q Query) ([]gotie.IOC, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(s.iocs))
+	for k, ioc := range s.iocs {
+		if q.Match(ioc) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	if q.Offset > 0 {
+		if q.Offset >= len(keys) {
+			keys = nil
+		} else {
+			keys = keys[q.Offset:]
+		}
+	}
+	if q.Limit > 0 && len(keys) > q.Limit {
+		keys = keys[:q.Limit]
+	}
+
+	iocs := make([]gotie.IOC, len(keys))
+	for i, k := range keys {
+		iocs[i] = *s.iocs[k]
+	}
+	return iocs, nil
+}
+
+// Compact atomically replaces the log by one holding only the current
+// IOCs
+func (s *Store) Compact() error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	err := atomicfile.Write(s.path, func(f io.Writer) error {
+		w := bufio.NewWriter(f)
+		enc := json.NewEncoder(w)
+		for _, ioc := range s.iocs {
+			if err := enc.Encode(&record{Op: "put", IOC: ioc}); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return err
+	}
+
+	s.f.Close()
+	s.f = f
+	s.garbage = 0
+	return nil
+}
+
+// Garbage returns the number of log records superseded by later ones,
+// which Compact would remove
+func (s *Store) Garbage() int {
+	return s.garbage
+}
+
+// Close closes the log file
+func (s *Store) Close() error {
+	return s.f.Close()
+}
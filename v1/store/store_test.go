@@ -0,0 +1,187 @@
+package store
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DCSO/gotie/v1"
+)
+
+func date(day int) *time.Time {
+	t := time.Date(2018, 3, day, 0, 0, 0, 0, time.UTC)
+	return &t
+}
+
+var testIOCs = []gotie.IOC{
+	{ID: "1", DataType: "DomainName", Value: "evil.example.com", Categories: []string{"c2server"},
+		MaxSeverity: 4, MaxConfidence: 80, LastSeen: date(10)},
+	{ID: "2", DataType: "IPv4", Value: "192.0.2.1", Categories: []string{"phishing"},
+		MaxSeverity: 2, MaxConfidence: 40, LastSeen: date(2)},
+	{ID: "3", DataType: "DomainName", Value: "phish.example.org", Categories: []string{"phishing"},
+		MaxSeverity: 3, MaxConfidence: 60, LastSeen: date(5)},
+}
+
+func openTestStore(t *testing.T) (*Store, string) {
+	dir, err := ioutil.TempDir("", "gotie-store")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	s, err := Open(filepath.Join(dir, "iocs.log"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := s.Upsert(testIOCs); err != nil {
+		t.Fatalf(err.Error())
+	}
+	return s, dir
+}
+
+func TestStorePersistence(t *testing.T) {
+	s, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+
+	update := testIOCs[0]
+	update.MaxSeverity = 5
+	if err := s.Upsert([]gotie.IOC{update}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if n, err := s.Expire(*date(3)); err != nil || n != 1 {
+		t.Fatalf("expected 1 expired IOC, got %v (%v)", n, err)
+	}
+	s.Close()
+
+	// Simulate a crash while writing a record
+	path := filepath.Join(dir, "iocs.log")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	f.WriteString(`{"op":"put","ioc":{"id":"4",`)
+	f.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if s.Len() != 2 {
+		t.Errorf("expected 2 IOCs, got %v", s.Len())
+	}
+	if ioc, ok := s.Get("1"); !ok || ioc.MaxSeverity != 5 {
+		t.Errorf("update of IOC 1 lost: %+v", ioc)
+	}
+	if _, ok := s.Get("2"); ok {
+		t.Errorf("expired IOC 2 still stored")
+	}
+	if ioc, ok := s.Lookup("domainname", "Evil.Example.com."); !ok || ioc.ID != "1" {
+		t.Errorf("lookup by value failed: %+v", ioc)
+	}
+
+	if err := s.Compact(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := s.Upsert([]gotie.IOC{testIOCs[1]}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	s.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer s.Close()
+	if s.Len() != 3 || s.Garbage() != 0 {
+		t.Errorf("unexpected store after compaction: %v IOCs, %v garbage records", s.Len(), s.Garbage())
+	}
+}
+
+func TestStoreReadOnly(t *testing.T) {
+	s, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	s.Close()
+
+	path := filepath.Join(dir, "iocs.log")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	f.WriteString(`{"op":"put","ioc":{"id":"4",`)
+	f.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	s, err = OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer s.Close()
+	if s.Len() != 3 {
+		t.Errorf("expected 3 IOCs, got %v", s.Len())
+	}
+	if err := s.Upsert(testIOCs[:1]); err != ErrReadOnly {
+		t.Errorf("expected read-only error, got %v", err)
+	}
+	if err := s.Compact(); err != ErrReadOnly {
+		t.Errorf("expected read-only error, got %v", err)
+	}
+	if after, err := os.Stat(path); err != nil || after.Size() != info.Size() {
+		t.Errorf("read-only store was changed")
+	}
+
+	if _, err := OpenReadOnly(filepath.Join(dir, "missing.log")); !os.IsNotExist(err) {
+		t.Errorf("expected missing store to fail, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing.log")); !os.IsNotExist(err) {
+		t.Errorf("missing store was created")
+	}
+}
+
+func TestStoreQuery(t *testing.T) {
+	s, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	for _, c := range []struct {
+		q   Query
+		ids []string
+	}{
+		{Query{}, []string{"1", "2", "3"}},
+		{Query{DataType: "domainname"}, []string{"1", "3"}},
+		{Query{Value: "EXAMPLE"}, []string{"1", "3"}},
+		{Query{Severity: &gotie.Range{Min: 3, Max: -1}}, []string{"1", "3"}},
+		{Query{Confidence: &gotie.Range{Min: 0, Max: 60}}, []string{"2", "3"}},
+		{Query{Categories: []string{"Phishing"}}, []string{"2", "3"}},
+		{Query{LastSeen: Window{Since: *date(3), Until: *date(9)}}, []string{"3"}},
+		{Query{Offset: 1, Limit: 1}, []string{"2"}},
+	} {
+		iocs, err := s.Query(c.q)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		var ids []string
+		for _, ioc := range iocs {
+			ids = append(ids, ioc.ID)
+		}
+		if len(ids) != len(c.ids) {
+			t.Errorf("expected %v for %+v, got %v", c.ids, c.q, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != c.ids[i] {
+				t.Errorf("expected %v for %+v, got %v", c.ids, c.q, ids)
+				break
+			}
+		}
+	}
+
+	if _, err := s.Query(Query{Created: Window{Since: *date(5), Until: *date(1)}}); err == nil {
+		t.Errorf("expected error for invalid window")
+	}
+}
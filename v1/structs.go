@@ -18,6 +18,13 @@ type apiMessage struct {
 	Errors  interface{} `json:"errors,omitempty"`
 }
 
+// PingbackResponse is the response of the pingback API to an accepted
+// sighting
+type PingbackResponse struct {
+	StatusCode int    `json:"status_code"`
+	Message    string `json:"message"`
+}
+
 // IOC defines the basic data structure of IOCs in TIE
 type IOC struct {
 	ID                    string     `json:"id"`
@@ -47,6 +54,15 @@ type IOC struct {
 type IOCResult struct {
 	IOC   *IOC
 	Error error
+	// Page describes the result page the IOC was delivered with, shared by
+	// all IOCs of the page. It is nil for results not read from TIE pages.
+	Page *IOCPage
+}
+
+// IOCPage is the metadata of a page of IOC query results
+type IOCPage struct {
+	Params  IOCParams `json:"params"`
+	HasMore bool      `json:"has_more"`
 }
 
 // IOCParams contains all necessary query parameters
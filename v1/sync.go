@@ -0,0 +1,343 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DCSO/gotie/v1/internal/atomicfile"
+)
+
+// IOCStore persists the IOCs fetched by a Syncer
+type IOCStore interface {
+	// Upsert inserts the IOCs, replacing stored IOCs with the same ID. The
+	// IOCs must be persisted when Upsert returns, unless the store is an
+	// IOCFlusher.
+	Upsert(iocs []IOC) error
+}
+
+// IOCFlusher is implemented by IOCStores buffering upserted IOCs. Flush
+// persists them.
+type IOCFlusher interface {
+	Flush() error
+}
+
+// IOCKey returns the key identifying ioc in a store: its ID, or its data
+// type and value if it has none
+func IOCKey(ioc *IOC) string {
+	if ioc.ID != "" {
+		return ioc.ID
+	}
+	return ioc.DataType + ":" + ioc.Value
+}
+
+// JSONFileStore is an IOCStore keeping all IOCs in a JSON file, which is
+// rewritten by Flush. The file has the format of JSON results, so it can be
+// read by GetIOCJSONInChan.
+type JSONFileStore struct {
+	path  string
+	iocs  map[string]IOC
+	dirty bool
+}
+
+// OpenJSONFileStore opens the store in the file path, which is created by
+// the first Flush if it does not exist.
+func OpenJSONFileStore(path string) (*JSONFileStore, error) {
+	s := &JSONFileStore{
+		path: path,
+		iocs: make(map[string]IOC),
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var doc JSONTopLevelResponse
+	if err := json.NewDecoder(f).Decode(&doc); err != nil {
+		return nil, err
+	}
+	for _, ioc := range doc.IOCs {
+		s.iocs[IOCKey(&ioc)] = ioc
+	}
+
+	return s, nil
+}
+
+// IOCs returns the stored IOCs ordered by their key
+func (s *JSONFileStore) IOCs() []IOC {
+	keys := make([]string, 0, len(s.iocs))
+	for key := range s.iocs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	iocs := make([]IOC, 0, len(keys))
+	for _, key := range keys {
+		iocs = append(iocs, s.iocs[key])
+	}
+	return iocs
+}
+
+// Upsert inserts the IOCs in memory. They are written to the file by Flush.
+func (s *JSONFileStore) Upsert(iocs []IOC) error {
+	for _, ioc := range iocs {
+		s.iocs[IOCKey(&ioc)] = ioc
+	}
+	if len(iocs) > 0 {
+		s.dirty = true
+	}
+	return nil
+}
+
+// Flush atomically rewrites the file if IOCs were upserted since the last
+// Flush
+func (s *JSONFileStore) Flush() error {
+	if !s.dirty {
+		return nil
+	}
+
+	err := atomicfile.Write(s.path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(&struct {
+			IOCs []IOC `json:"iocs"`
+		}{s.IOCs()})
+	})
+	if err == nil {
+		s.dirty = false
+	}
+	return err
+}
+
+// SyncState holds the high-water marks of synchronised queries, i.e. the
+// latest updated_at time of the IOCs fetched so far.
+type SyncState struct {
+	Marks map[string]time.Time `json:"marks"`
+}
+
+// LoadSyncState reads the state file path. A missing file yields an empty
+// state.
+func LoadSyncState(path string) (*SyncState, error) {
+	state := &SyncState{Marks: make(map[string]time.Time)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Marks == nil {
+		state.Marks = make(map[string]time.Time)
+	}
+
+	return state, nil
+}
+
+// Save atomically writes the state to path
+func (s *SyncState) Save(path string) error {
+	return atomicfile.Write(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	})
+}
+
+// SyncQuery selects the IOCs kept in sync by a Syncer
+type SyncQuery struct {
+	Query    string
+	DataType string
+	Args     *IOCQuery
+}
+
+// Key identifies the query in the sync state. An updated_since argument is
+// not part of the key, as it only determines the start of the first sync.
+func (q SyncQuery) Key() (string, error) {
+	values, err := q.Args.Values()
+	if err != nil {
+		return "", err
+	}
+	values.Del("updated_since")
+	values.Set("query", q.Query)
+	values.Set("data_type", strings.ToLower(q.DataType))
+	return values.Encode(), nil
+}
+
+// Syncer incrementally synchronises IOCs into an IOCStore. Every sync only
+// requests the IOCs updated since the latest update seen by the previous
+// sync of the same query. Rather than by offset, the results are paged by
+// update time, so IOCs updated during a sync do not shift the pages. The
+// state is saved at checkpoints once the store holds the IOCs fetched so
+// far, so an interrupted sync resumes where it stopped.
+type Syncer struct {
+	Client *Client
+	Store  IOCStore
+	// StatePath is the file holding the sync state
+	StatePath string
+	// Checkpoint is the number of IOCs after which an IOCFlusher store is
+	// flushed and the state saved. Other stores are checkpointed after
+	// every page.
+	Checkpoint int
+}
+
+func NewSyncer(c *Client, store IOCStore, statePath string) *Syncer {
+	return &Syncer{
+		Client:     c,
+		Store:      store,
+		StatePath:  statePath,
+		Checkpoint: 100000,
+	}
+}
+
+// Sync fetches the IOCs of q updated since the last sync and returns their
+// number
+func (s *Syncer) Sync(q SyncQuery) (int, error) {
+	return s.SyncContext(context.Background(), q)
+}
+
+// syncKey identifies a version of an IOC, as a sync may return an IOC
+// again, either unchanged or updated in the meantime
+func syncKey(ioc *IOC) string {
+	if ioc.UpdatedAt == nil {
+		return IOCKey(ioc)
+	}
+	return IOCKey(ioc) + "@" + ioc.UpdatedAt.Format(time.RFC3339Nano)
+}
+
+// SyncContext is like Sync but aborts when ctx is cancelled. The IOCs of
+// pages fetched so far are kept.
+//
+// The pages are requested with updated_since set to the latest update time
+// seen, which TIE compares at second precision. IOCs updated within that
+// second are returned again and skipped. If a whole page was updated in the
+// same second, the next page is requested by offset within it.
+func (s *Syncer) SyncContext(ctx context.Context, q SyncQuery) (n int, err error) {
+	key, err := q.Key()
+	if err != nil {
+		return 0, err
+	}
+	state, err := LoadSyncState(s.StatePath)
+	if err != nil {
+		return 0, err
+	}
+	mark := state.Marks[key]
+	flusher, _ := s.Store.(IOCFlusher)
+
+	checkpoint := func() error {
+		if flusher != nil {
+			if err := flusher.Flush(); err != nil {
+				return err
+			}
+		}
+		if !mark.IsZero() {
+			state.Marks[key] = mark
+		}
+		s.Client.debugf("sync: %d IOCs stored, mark %v", n, mark)
+		return state.Save(s.StatePath)
+	}
+
+	var (
+		// seen holds the IOCs fetched in the second of mark
+		seen      = make(map[string]bool)
+		offset    int
+		unflushed int
+	)
+	for {
+		args := q.Args.Clone()
+		if !mark.IsZero() {
+			args.UpdatedSince(mark)
+		}
+		args.OrderBy("updated_at", "asc")
+		if offset > 0 {
+			args.Offset(offset)
+		}
+		request := &IOCRequest{
+			Query:    q.Query,
+			DataType: q.DataType,
+			Args:     args,
+			MimeType: JSON,
+		}
+		url, err := request.URL(s.Client.apiURL, s.Client.iocLimit)
+		if err != nil {
+			return n, err
+		}
+
+		var buf bytes.Buffer
+		next, err := s.Client.doIteration(ctx, url, JSON, &buf)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			if cerr := checkpoint(); cerr != nil {
+				s.Client.logger.Printf("sync: %v", cerr)
+			}
+			return n, err
+		}
+
+		var tlr JSONTopLevelResponse
+		if err := json.Unmarshal(buf.Bytes(), &tlr); err != nil {
+			return n, fmt.Errorf("sync: %v", err)
+		}
+
+		var fresh []IOC
+		advanced := false
+		for i := range tlr.IOCs {
+			ioc := &tlr.IOCs[i]
+			if seen[syncKey(ioc)] {
+				continue
+			}
+			fresh = append(fresh, *ioc)
+
+			if ioc.UpdatedAt == nil {
+				continue
+			}
+			if updated := ioc.UpdatedAt.Truncate(time.Second); updated.After(mark.Truncate(time.Second)) {
+				seen = make(map[string]bool)
+				advanced = true
+			}
+			if ioc.UpdatedAt.After(mark) {
+				mark = *ioc.UpdatedAt
+			}
+			seen[syncKey(ioc)] = true
+		}
+
+		if err := s.Store.Upsert(fresh); err != nil {
+			return n, err
+		}
+		n += len(fresh)
+		unflushed += len(fresh)
+
+		if next == nil || len(tlr.IOCs) == 0 {
+			break
+		}
+		if advanced {
+			offset = 0
+		} else {
+			offset += len(tlr.IOCs)
+		}
+
+		if flusher == nil || unflushed >= s.Checkpoint {
+			if err := checkpoint(); err != nil {
+				return n, err
+			}
+			unflushed = 0
+		}
+	}
+
+	return n, checkpoint()
+}
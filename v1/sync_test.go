@@ -0,0 +1,175 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncServer serves iocs like TIE, filtered by updated_since at second
+// precision and ordered by update time, calling update after the first
+// request
+func syncServer(t *testing.T, iocs []IOC, update func([]IOC)) (*httptest.Server, func() []string) {
+	var (
+		mu      sync.Mutex
+		queries []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		params := r.URL.Query()
+		if params.Get("order_by") != "updated_at" || params.Get("direction") != "asc" {
+			t.Errorf("results are not ordered by update time: %v", r.URL)
+		}
+		queries = append(queries, params.Get("updated_since")+" "+params.Get("offset"))
+
+		var since time.Time
+		if s := params.Get("updated_since"); s != "" {
+			since, _ = time.Parse(queryTimeFormat, s)
+		}
+		limit, _ := strconv.Atoi(params.Get("limit"))
+		offset, _ := strconv.Atoi(params.Get("offset"))
+
+		var page []IOC
+		for _, ioc := range iocs {
+			if !ioc.UpdatedAt.Before(since) {
+				page = append(page, ioc)
+			}
+		}
+		sort.SliceStable(page, func(i, j int) bool {
+			return page[i].UpdatedAt.Before(*page[j].UpdatedAt)
+		})
+		if offset < len(page) {
+			page = page[offset:]
+		} else {
+			page = nil
+		}
+		if len(page) > limit {
+			page = page[:limit]
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/next>; rel="next"`, r.Host))
+		}
+		json.NewEncoder(w).Encode(&IOCQueryStruct{Iocs: page})
+
+		if len(queries) == 1 && update != nil {
+			update(iocs)
+		}
+	}))
+
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), queries...)
+	}
+}
+
+func syncFixture(id string, updated string, severity int) IOC {
+	at, _ := time.Parse(time.RFC3339, updated)
+	return IOC{ID: id, Value: id + ".example.com", DataType: "DomainName", MaxSeverity: severity, UpdatedAt: &at}
+}
+
+func TestSyncer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotie-sync")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	iocs := []IOC{
+		syncFixture("a", "2018-03-01T10:00:00Z", 1),
+		syncFixture("b", "2018-03-01T11:00:00Z", 1),
+		syncFixture("c", "2018-03-01T11:30:00Z", 1),
+		syncFixture("d", "2018-03-01T12:00:00Z", 1),
+		syncFixture("e", "2018-03-01T12:30:00Z", 1),
+		// more IOCs updated within a second than fit on a page
+		syncFixture("f", "2018-03-01T14:00:00.1Z", 1),
+		syncFixture("g", "2018-03-01T14:00:00.2Z", 1),
+		syncFixture("h", "2018-03-01T14:00:00.3Z", 1),
+	}
+	// a is updated after the first page, moving it behind the others, which
+	// shifts all offsets
+	srv, queries := syncServer(t, iocs, func(iocs []IOC) {
+		iocs[0] = syncFixture("a", "2018-03-01T13:00:00Z", 4)
+	})
+	defer srv.Close()
+
+	store, err := OpenJSONFileStore(filepath.Join(dir, "iocs.json"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	statePath := filepath.Join(dir, "state.json")
+	syncer := NewSyncer(NewClient(WithAPIURL(srv.URL+"/"), WithIOCLimit(2), WithPageDelay(0)), store, statePath)
+	q := SyncQuery{DataType: "DomainName", Args: NewIOCQuery().Categories("c2server")}
+
+	n, err := syncer.Sync(q)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if n != 9 {
+		t.Errorf("expected 9 synced IOCs, got %v", n)
+	}
+
+	store, err = OpenJSONFileStore(filepath.Join(dir, "iocs.json"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	stored := store.IOCs()
+	if len(stored) != 8 || stored[0].ID != "a" || stored[0].MaxSeverity != 4 || stored[7].ID != "h" {
+		t.Errorf("unexpected stored IOCs %+v", stored)
+	}
+
+	state, err := LoadSyncState(statePath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	key, _ := q.Key()
+	if mark := state.Marks[key]; !mark.Equal(*iocs[7].UpdatedAt) {
+		t.Errorf("unexpected mark %v", mark)
+	}
+
+	before := len(queries())
+	if _, err := syncer.Sync(q); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if got := queries(); len(got) <= before || got[0] != " " || got[before] != "2018-03-01T14:00:00Z " {
+		t.Errorf("unexpected updated_since and offset arguments %q", got)
+	}
+}
+
+func TestJSONFileStoreFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotie-sync")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "iocs.json")
+
+	store, err := OpenJSONFileStore(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := store.Upsert([]IOC{syncFixture("a", "2018-03-01T10:00:00Z", 1)}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("store was written before Flush")
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if store, err = OpenJSONFileStore(path); err != nil || len(store.IOCs()) != 1 {
+		t.Fatalf("unexpected store after Flush: %v", err)
+	}
+}
@@ -0,0 +1,563 @@
+// Package tietest provides a fake TIE API for tests and offline
+// development. The Server serves IOC queries, feeds and pingback
+// submissions from fixture IOCs, paginated like TIE, and can inject
+// failures to exercise error handling and retries.
+package tietest
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DCSO/gotie/v1"
+	"github.com/DCSO/gotie/v1/store"
+)
+
+// DefaultLimit is the page size used for requests without limit
+const DefaultLimit = 1000
+
+// feedPeriods are the update windows of the supported feed periods
+var feedPeriods = map[string]time.Duration{
+	"hourly":  time.Hour,
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+}
+
+// Failure is an error response injected by Server.Fail
+type Failure struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	// Message is returned as TIE error message, defaulting to the status
+	// text
+	Message string
+	// RetryAfter sets the Retry-After header, unless zero
+	RetryAfter time.Duration
+	// CutBody sends the regular response instead, but closes the
+	// connection halfway through its body. StatusCode and Message are
+	// ignored.
+	CutBody bool
+}
+
+// Server is a fake TIE API. It serves
+//
+//	/iocs                        IOC queries
+//	/iocs/feed/{period}/{type}   feeds of the IOCs updated within the period
+//	/submit/                     pingback submissions
+//
+// IOC results are returned as JSON, CSV, Bloom filter or STIX 1.x
+// depending on the Accept header. Pages hold at most limit IOCs, and a
+// Link header points to the next page.
+//
+// The settings of a Server can be changed while it serves requests.
+type Server struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	maxLimit      int
+	token         string
+	pingbackToken string
+	iocs          []gotie.IOC
+	failures      []Failure
+	sightings     []gotie.Sighting
+	requests      []string
+	now           func() time.Time
+}
+
+// NewServer starts a Server serving iocs. It has to be closed after use.
+func NewServer(iocs ...gotie.IOC) *Server {
+	s := &Server{
+		iocs: append([]gotie.IOC(nil), iocs...),
+		now:  time.Now,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// APIURL returns the base URL of the IOC API, for gotie.WithAPIURL
+func (s *Server) APIURL() string {
+	return s.URL + "/"
+}
+
+// PingbackURL returns the URL of the pingback API, for
+// gotie.WithPingbackURL
+func (s *Server) PingbackURL() string {
+	return s.URL + "/submit/"
+}
+
+// SetToken sets the token required for IOC queries and feeds. Any token is
+// accepted if it is empty, which is the default.
+func (s *Server) SetToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// SetPingbackToken sets the token required for pingback submissions. Any
+// token is accepted if it is empty, which is the default.
+func (s *Server) SetPingbackToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pingbackToken = token
+}
+
+// SetMaxLimit caps the page size below the requested limit, as TIE does
+// for large limits. Zero, the default, serves pages of the requested size.
+func (s *Server) SetMaxLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxLimit = n
+}
+
+// tokens returns the tokens required for IOC queries and pingbacks
+func (s *Server) tokens() (token, pingbackToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token, s.pingbackToken
+}
+
+// NewClient returns a gotie.Client talking to the server with its current
+// tokens and without delays between pages. Further options are applied
+// afterwards.
+func (s *Server) NewClient(opts ...gotie.ClientOption) *gotie.Client {
+	token, pingbackToken := s.tokens()
+	return gotie.NewClient(append([]gotie.ClientOption{
+		gotie.WithAPIURL(s.APIURL()),
+		gotie.WithPingbackURL(s.PingbackURL()),
+		gotie.WithAuthToken(token),
+		gotie.WithPingbackToken(pingbackToken),
+		gotie.WithHTTPClient(s.Client()),
+		gotie.WithPageDelay(0),
+	}, opts...)...)
+}
+
+// SetIOCs replaces the served IOCs
+func (s *Server) SetIOCs(iocs ...gotie.IOC) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.iocs = append([]gotie.IOC(nil), iocs...)
+}
+
+// Fail makes the next n requests fail with f
+func (s *Server) Fail(n int, f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, f)
+	}
+}
+
+// Sightings returns the sightings submitted so far
+func (s *Server) Sightings() []gotie.Sighting {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]gotie.Sighting(nil), s.sightings...)
+}
+
+// Requests returns the request URIs received so far
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// writeError writes a TIE error response
+func writeError(w http.ResponseWriter, code int, message string, errors interface{}) {
+	if message == "" {
+		message = http.StatusText(code)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+		"errors":  errors,
+	})
+}
+
+// authorized reports whether r carries token, if one is required
+func authorized(r *http.Request, token string) bool {
+	return token == "" || r.Header.Get("Authorization") == "Bearer "+token
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL.RequestURI())
+	var failure *Failure
+	if len(s.failures) > 0 {
+		failure = &s.failures[0]
+		s.failures = s.failures[1:]
+	}
+	s.mu.Unlock()
+
+	if failure != nil && failure.CutBody {
+		rec := httptest.NewRecorder()
+		s.route(rec, r)
+		cutBody(w, rec)
+		return
+	} else if failure != nil {
+		if failure.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((failure.RetryAfter+time.Second-1)/time.Second)))
+		}
+		writeError(w, failure.StatusCode, failure.Message, nil)
+		return
+	}
+
+	s.route(w, r)
+}
+
+// cutBody sends the response recorded in rec with its full length, but
+// only the first half of the body, and closes the connection
+func cutBody(w http.ResponseWriter, rec *httptest.ResponseRecorder) {
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	body := rec.Body.Bytes()
+	header := rec.Header()
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	fmt.Fprintf(buf, "HTTP/1.1 %d %s\r\n", rec.Code, http.StatusText(rec.Code))
+	header.Write(buf)
+	buf.WriteString("\r\n")
+	buf.Write(body[:len(body)/2])
+	buf.Flush()
+}
+
+// route passes r to the handler of its path
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/submit":
+		s.serveSubmit(w, r)
+	case path == "/iocs":
+		s.serveIOCs(w, r, "")
+	case strings.HasPrefix(path, "/iocs/feed/"):
+		parts := strings.Split(strings.TrimPrefix(path, "/iocs/feed/"), "/")
+		if len(parts) != 2 {
+			writeError(w, http.StatusNotFound, "", nil)
+			return
+		}
+		r.URL.RawQuery += "&data_type=" + url.QueryEscape(parts[1])
+		s.serveIOCs(w, r, parts[0])
+	default:
+		writeError(w, http.StatusNotFound, "", nil)
+	}
+}
+
+func (s *Server) serveSubmit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "", nil)
+		return
+	}
+	if _, token := s.tokens(); !authorized(r, token) {
+		writeError(w, http.StatusUnauthorized, "invalid token", nil)
+		return
+	}
+
+	dataType, ok := gotie.CanonicalDataType(r.PostFormValue("data_type"))
+	if !ok {
+		writeError(w, http.StatusUnprocessableEntity, "invalid sighting",
+			map[string][]string{"data_type": {"is not a valid data type"}})
+		return
+	}
+	value := r.PostFormValue("value")
+	if value == "" {
+		writeError(w, http.StatusUnprocessableEntity, "invalid sighting",
+			map[string][]string{"value": {"must not be empty"}})
+		return
+	}
+	seen, err := time.Parse(time.RFC3339, r.PostFormValue("seen"))
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid sighting",
+			map[string][]string{"seen": {"is not a valid time"}})
+		return
+	}
+
+	s.mu.Lock()
+	s.sightings = append(s.sightings, gotie.Sighting{DataType: dataType, Value: value, Seen: seen})
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, `{"message": "sighting submitted"}`)
+}
+
+// parseTime parses the date parameters of IOC queries
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-1-2"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// query builds the store query selecting the IOCs requested by params
+func (s *Server) query(params url.Values, period string) (q store.Query, err error) {
+	q.Value = params.Get("ivalue")
+	q.DataType = params.Get("data_type")
+
+	for _, r := range []struct {
+		name  string
+		field **gotie.Range
+	}{
+		{"severity", &q.Severity},
+		{"confidence", &q.Confidence},
+	} {
+		if v := params.Get(r.name); v != "" {
+			rng, err := gotie.ParseRange(v)
+			if err != nil {
+				return q, err
+			}
+			*r.field = &rng
+		}
+	}
+
+	for _, l := range []struct {
+		name  string
+		field *[]string
+	}{
+		{"category", &q.Categories},
+		{"source_pseudonym", &q.SourcePseudonyms},
+	} {
+		if v := params.Get(l.name); v != "" {
+			*l.field = strings.Split(v, ",")
+		}
+	}
+
+	for _, d := range []struct {
+		name   string
+		window *store.Window
+	}{
+		{"created", &q.Created},
+		{"updated", &q.Updated},
+		{"first_seen", &q.FirstSeen},
+		{"last_seen", &q.LastSeen},
+	} {
+		for _, bound := range []struct {
+			suffix string
+			field  *time.Time
+		}{
+			{"_since", &d.window.Since},
+			{"_until", &d.window.Until},
+		} {
+			if v := params.Get(d.name + bound.suffix); v != "" {
+				if *bound.field, err = parseTime(v); err != nil {
+					return q, err
+				}
+			}
+		}
+	}
+
+	if period != "" {
+		d, ok := feedPeriods[period]
+		if !ok {
+			return q, fmt.Errorf("invalid period %q", period)
+		}
+		q.Updated.Since = s.now().Add(-d)
+	}
+
+	return q, nil
+}
+
+// orderKeys are the IOC fields results can be ordered by
+var orderKeys = map[string]func(*gotie.IOC) string{
+	"id":    func(ioc *gotie.IOC) string { return ioc.ID },
+	"value": func(ioc *gotie.IOC) string { return ioc.Value },
+	"created_at": func(ioc *gotie.IOC) string {
+		return formatTime(ioc.CreatedAt)
+	},
+	"updated_at": func(ioc *gotie.IOC) string {
+		return formatTime(ioc.UpdatedAt)
+	},
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// intParam returns the non-negative integer parameter name, or def
+func intParam(params url.Values, name string, def int) (int, error) {
+	v := params.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %v %q", name, v)
+	}
+	return n, nil
+}
+
+func (s *Server) serveIOCs(w http.ResponseWriter, r *http.Request, period string) {
+	if token, _ := s.tokens(); !authorized(r, token) {
+		writeError(w, http.StatusUnauthorized, "invalid token", nil)
+		return
+	}
+
+	params := r.URL.Query()
+	q, err := s.query(params, period)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	limit, err := intParam(params, "limit", DefaultLimit)
+	if err == nil && limit == 0 {
+		limit = DefaultLimit
+	}
+	s.mu.Lock()
+	if s.maxLimit > 0 && limit > s.maxLimit {
+		limit = s.maxLimit
+	}
+	s.mu.Unlock()
+	offset, oerr := intParam(params, "offset", 0)
+	if err == nil {
+		err = oerr
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	var iocs []gotie.IOC
+	for i := range s.iocs {
+		if q.Match(&s.iocs[i]) {
+			iocs = append(iocs, s.iocs[i])
+		}
+	}
+	s.mu.Unlock()
+
+	if field := params.Get("order_by"); field != "" {
+		key, ok := orderKeys[field]
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid order_by %q", field), nil)
+			return
+		}
+		desc := params.Get("direction") == "desc"
+		sort.SliceStable(iocs, func(i, j int) bool {
+			if desc {
+				return key(&iocs[i]) > key(&iocs[j])
+			}
+			return key(&iocs[i]) < key(&iocs[j])
+		})
+	}
+
+	hasMore := false
+	if offset >= len(iocs) {
+		iocs = nil
+	} else {
+		iocs = iocs[offset:]
+	}
+	if len(iocs) > limit {
+		iocs = iocs[:limit]
+		hasMore = true
+	}
+
+	if hasMore {
+		next := *r.URL
+		values := next.Query()
+		values.Set("offset", strconv.Itoa(offset+limit))
+		next.RawQuery = values.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s%s>; rel="next"`, r.Host, next.RequestURI()))
+	}
+
+	page := gotie.IOCQueryStruct{
+		HasMore: hasMore,
+		Iocs:    iocs,
+		Params: gotie.IOCParams{
+			Ivalue:    q.Value,
+			Limit:     limit,
+			Offset:    offset,
+			OrderBy:   params.Get("order_by"),
+			Direction: params.Get("direction"),
+		},
+	}
+
+	accept := gotie.MimeType(r.Header.Get("Accept"))
+	switch accept {
+	case gotie.JSON, "":
+		w.Header().Set("Content-Type", string(gotie.JSON))
+		json.NewEncoder(w).Encode(&page)
+	case gotie.CSV:
+		w.Header().Set("Content-Type", string(gotie.CSV))
+		cw := gotie.NewCSVWriter(w)
+		for i := range iocs {
+			cw.WriteIOC(&iocs[i])
+		}
+		cw.Close()
+	case gotie.BLOOMv1, gotie.BLOOMv2:
+		s.writeBloom(w, params, accept, iocs)
+	case gotie.STIX:
+		w.Header().Set("Content-Type", string(gotie.STIX))
+		writeSTIX(w, iocs)
+	default:
+		writeError(w, http.StatusNotAcceptable, fmt.Sprintf("unsupported type %q", accept), nil)
+	}
+}
+
+func (s *Server) writeBloom(w http.ResponseWriter, params url.Values, t gotie.MimeType, iocs []gotie.IOC) {
+	b := gotie.NewBloomBuilder()
+	b.Capacity = 100000
+	if v := params.Get("n"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid n %q", v), nil)
+			return
+		}
+		b.Capacity = n
+	}
+	if v := params.Get("p"); v != "" {
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil || p <= 0 || p >= 1 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid p %q", v), nil)
+			return
+		}
+		b.FalsePositiveRate = p
+	}
+	for i := range iocs {
+		b.Add(&iocs[i])
+	}
+
+	w.Header().Set("Content-Type", string(t))
+	b.Filter().Write(w)
+}
+
+// writeSTIX writes iocs as STIX 1.2 package of observables
+func writeSTIX(w io.Writer, iocs []gotie.IOC) {
+	fmt.Fprint(w, xml.Header)
+	fmt.Fprint(w, `<stix:STIX_Package xmlns:stix="http://stix.mitre.org/stix-1" `+
+		`xmlns:cybox="http://cybox.mitre.org/cybox-2" xmlns:dcso="https://tie.dcso.de" `+
+		`id="dcso:Package-tietest" version="1.2">`+"\n")
+	fmt.Fprint(w, "\t<stix:STIX_Header>\n\t\t<stix:Title>DCSO TIE IOC export</stix:Title>\n\t</stix:STIX_Header>\n")
+	fmt.Fprint(w, "\t<stix:Observables cybox_major_version=\"2\" cybox_minor_version=\"1\">\n")
+	for _, ioc := range iocs {
+		fmt.Fprintf(w, "\t\t<cybox:Observable id=\"dcso:Observable-%s\">\n\t\t\t<cybox:Title>", escape(ioc.ID))
+		fmt.Fprint(w, escape(ioc.Value))
+		fmt.Fprintf(w, "</cybox:Title>\n\t\t\t<cybox:Description>%s</cybox:Description>\n\t\t</cybox:Observable>\n",
+			escape(ioc.DataType))
+	}
+	fmt.Fprint(w, "\t</stix:Observables>\n</stix:STIX_Package>\n")
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
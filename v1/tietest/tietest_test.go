@@ -0,0 +1,300 @@
+package tietest
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DCSO/bloom"
+	"github.com/DCSO/gotie/v1"
+)
+
+func fixtures(n int) []gotie.IOC {
+	iocs := make([]gotie.IOC, n)
+	for i := range iocs {
+		updated := time.Now().Add(-time.Duration(i) * time.Hour)
+		iocs[i] = gotie.IOC{
+			ID:          fmt.Sprintf("ioc-%d", i),
+			Value:       fmt.Sprintf("host%d.example.com", i),
+			DataType:    "DomainName",
+			MaxSeverity: i % 5,
+			Categories:  []string{"c2server"},
+			UpdatedAt:   &updated,
+		}
+	}
+	return iocs
+}
+
+func collect(t *testing.T, buf *bytes.Buffer) []gotie.IOC {
+	ch, err := gotie.GetIOCJSONInChan(buf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	res, err := gotie.IOCChanCollect(ch)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return res.Iocs
+}
+
+func TestServerPaging(t *testing.T) {
+	srv := NewServer(fixtures(5)...)
+	defer srv.Close()
+	client := srv.NewClient(gotie.WithIOCLimit(2))
+
+	var buf bytes.Buffer
+	if err := client.WriteIOCs("example", "domainname", "", "json", &buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	iocs := collect(t, &buf)
+	if len(iocs) != 5 || iocs[0].ID != "ioc-0" || iocs[4].ID != "ioc-4" {
+		t.Fatalf("unexpected IOCs %v", iocs)
+	}
+
+	requests := srv.Requests()
+	if len(requests) != 3 || !strings.Contains(requests[2], "offset=4") {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+
+	buf.Reset()
+	args := gotie.NewIOCQuery().Severity(gotie.Range{Min: 3, Max: -1}).OrderBy("id", "desc")
+	request := &gotie.IOCRequest{DataType: "domainname", Args: args}
+	if err := client.Do(request, gotie.JSON, &buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	iocs = collect(t, &buf)
+	if len(iocs) != 2 || iocs[0].ID != "ioc-4" || iocs[1].ID != "ioc-3" {
+		t.Fatalf("unexpected filtered IOCs %v", iocs)
+	}
+}
+
+func TestServerFormats(t *testing.T) {
+	srv := NewServer(fixtures(3)...)
+	defer srv.Close()
+	client := srv.NewClient(gotie.WithIOCLimit(2))
+
+	var buf bytes.Buffer
+	if err := client.WriteIOCs("", "domainname", "", "csv", &buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 4 {
+		t.Fatalf("expected header and 3 CSV lines, got %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := client.WriteIOCs("", "domainname", "", "bloom", &buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	filter, err := bloom.LoadFromReader(&buf, false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, ioc := range fixtures(3) {
+		if !filter.Check([]byte(ioc.Value)) {
+			t.Errorf("%v is missing from the bloom filter", ioc.Value)
+		}
+	}
+
+	buf.Reset()
+	if err := client.WriteIOCs("", "domainname", "", "stix", &buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if n := strings.Count(buf.String(), "<cybox:Observable "); n != 3 {
+		t.Fatalf("expected 3 STIX observables, got %d:\n%s", n, buf.String())
+	}
+}
+
+func TestServerFeed(t *testing.T) {
+	srv := NewServer(fixtures(30)...)
+	defer srv.Close()
+	client := srv.NewClient()
+
+	var buf bytes.Buffer
+	if err := client.WritePeriodFeeds("daily", "domainname", "", "json", &buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if iocs := collect(t, &buf); len(iocs) != 24 {
+		t.Fatalf("expected 24 IOCs updated within a day, got %d", len(iocs))
+	}
+
+	err := client.WritePeriodFeeds("foobar", "domainname", "", "json", &buf)
+	if !gotie.IsInvalidRequest(err) || !strings.Contains(err.Error(), "invalid period") {
+		t.Fatalf("expected invalid period error, got %v", err)
+	}
+}
+
+func TestServerFailures(t *testing.T) {
+	srv := NewServer(fixtures(1)...)
+	defer srv.Close()
+	srv.SetToken("secret")
+
+	client := srv.NewClient(gotie.WithRetries(3, time.Millisecond))
+	srv.Fail(1, Failure{StatusCode: http.StatusServiceUnavailable})
+	srv.Fail(1, Failure{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Millisecond})
+
+	var buf bytes.Buffer
+	if err := client.WriteIOCs("", "domainname", "", "json", &buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if n := len(srv.Requests()); n != 3 {
+		t.Fatalf("expected 2 retries, got %d requests", n)
+	}
+
+	srv.Fail(3, Failure{StatusCode: http.StatusBadGateway, Message: "upstream down"})
+	err := client.WriteIOCs("", "domainname", "", "json", &buf)
+	if !gotie.IsServerError(err) || !strings.Contains(err.Error(), "upstream down") {
+		t.Fatalf("expected server error, got %v", err)
+	}
+
+	err = srv.NewClient(gotie.WithAuthToken("wrong")).WriteIOCs("", "domainname", "", "json", &buf)
+	if !gotie.IsUnauthorized(err) {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+}
+
+func TestServerCutBody(t *testing.T) {
+	srv := NewServer(fixtures(5)...)
+	defer srv.Close()
+
+	for _, format := range []string{"json", "csv", "stix"} {
+		var expected bytes.Buffer
+		client := srv.NewClient(gotie.WithIOCLimit(2), gotie.WithRetries(3, time.Millisecond))
+		if err := client.WriteIOCs("", "domainname", "", format, &expected); err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		// the first page is cut off and fetched again
+		for _, parallel := range []int{1, 3} {
+			client := srv.NewClient(
+				gotie.WithIOCLimit(2),
+				gotie.WithRetries(3, time.Millisecond),
+				gotie.WithParallelPages(parallel),
+			)
+			srv.Fail(1, Failure{CutBody: true})
+
+			var buf bytes.Buffer
+			if err := client.WriteIOCs("", "domainname", "", format, &buf); err != nil {
+				t.Fatalf("%v: %v", format, err)
+			}
+			if buf.String() != expected.String() {
+				t.Errorf("%v, %d parallel pages: retried output differs:\n%s\nexpected:\n%s", format, parallel, buf.String(), expected.String())
+			}
+		}
+	}
+}
+
+func TestServerPingback(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.SetPingbackToken("secret")
+	client := srv.NewClient()
+
+	seen := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	resp, err := client.SubmitSighting(gotie.Sighting{DataType: "DomainName", Value: "example.com", Seen: seen})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	sightings := srv.Sightings()
+	if len(sightings) != 1 || sightings[0].Value != "example.com" || !sightings[0].Seen.Equal(seen) {
+		t.Fatalf("unexpected sightings %v", sightings)
+	}
+
+	if err := client.PingBackCall("Foo", "example.com"); !gotie.IsInvalidRequest(err) {
+		t.Fatalf("expected invalid request error, got %v", err)
+	}
+}
+
+func TestServerChan(t *testing.T) {
+	srv := NewServer(fixtures(5)...)
+	defer srv.Close()
+	client := srv.NewClient(gotie.WithIOCLimit(2))
+
+	var (
+		iocs  []*gotie.IOC
+		pages []*gotie.IOCPage
+	)
+	for res := range client.GetIOCChan("", "domainname", "") {
+		if res.Error != nil {
+			t.Fatalf(res.Error.Error())
+		}
+		iocs = append(iocs, res.IOC)
+		pages = append(pages, res.Page)
+	}
+	if len(iocs) != 5 {
+		t.Fatalf("expected 5 IOCs, got %d", len(iocs))
+	}
+	for i, ioc := range iocs {
+		if want := fmt.Sprintf("ioc-%d", i); ioc.ID != want {
+			t.Errorf("IOC %d: expected %v, got %v", i, want, ioc.ID)
+		}
+		if i > 0 && ioc == iocs[i-1] {
+			t.Errorf("IOC %d reuses the memory of the previous one", i)
+		}
+	}
+	if pages[0] != pages[1] || pages[0] == pages[2] {
+		t.Errorf("IOCs of a page should share its metadata")
+	}
+	if !pages[0].HasMore || pages[0].Params.Limit != 2 || pages[2].Params.Offset != 2 || pages[4].HasMore {
+		t.Errorf("unexpected page metadata %+v, %+v, %+v", *pages[0], *pages[2], *pages[4])
+	}
+}
+
+func TestServerParallelPages(t *testing.T) {
+	iocs := fixtures(9)
+	srv := NewServer(iocs...)
+	defer srv.Close()
+
+	var sequential bytes.Buffer
+	if err := srv.NewClient(gotie.WithIOCLimit(2)).WriteIOCs("", "domainname", "", "json", &sequential); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	client := srv.NewClient(gotie.WithIOCLimit(2), gotie.WithParallelPages(3))
+	var buf bytes.Buffer
+	if err := client.WriteIOCs("", "domainname", "", "json", &buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if buf.String() != sequential.String() {
+		t.Fatalf("parallel output differs:\n%s\nsequential:\n%s", buf.String(), sequential.String())
+	}
+
+	var ids []string
+	for res := range client.GetIOCChan("", "domainname", "") {
+		if res.Error != nil {
+			t.Fatalf(res.Error.Error())
+		}
+		ids = append(ids, res.IOC.ID)
+	}
+	if got := strings.Join(ids, ","); got != "ioc-0,ioc-1,ioc-2,ioc-3,ioc-4,ioc-5,ioc-6,ioc-7,ioc-8" {
+		t.Fatalf("unexpected IOCs from channel: %v", got)
+	}
+
+	// TIE returns fewer IOCs per page than requested
+	srv.SetMaxLimit(2)
+	buf.Reset()
+	client = srv.NewClient(gotie.WithIOCLimit(5), gotie.WithParallelPages(3))
+	if err := client.WriteIOCs("", "domainname", "", "json", &buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if buf.String() != sequential.String() {
+		t.Fatalf("capped parallel output differs:\n%s\nsequential:\n%s", buf.String(), sequential.String())
+	}
+
+	srv = NewServer(iocs...)
+	defer srv.Close()
+	srv.Fail(1, Failure{StatusCode: http.StatusBadRequest, Message: "bad page"})
+	client = srv.NewClient(gotie.WithIOCLimit(2), gotie.WithParallelPages(3), gotie.WithRetryPolicy(nil))
+	if err := client.WriteIOCs("", "domainname", "", "json", &buf); !gotie.IsInvalidRequest(err) {
+		t.Fatalf("expected invalid request error, got %v", err)
+	}
+}
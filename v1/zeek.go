@@ -0,0 +1,131 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// zeekFields is the header of Zeek intel files written by ZeekIntelWriter
+var zeekFields = []string{"indicator", "indicator_type", "meta.source", "meta.desc"}
+
+// ZeekIndicatorType returns the Zeek Intel::Type and the indicator value for
+// ioc. It returns false for data types the Intelligence Framework can not
+// match.
+func ZeekIndicatorType(ioc *IOC) (indicatorType, indicator string, ok bool) {
+	dataType, _ := CanonicalDataType(ioc.DataType)
+
+	switch dataType {
+	case DataTypeDomainName:
+		return "Intel::DOMAIN", strings.ToLower(strings.TrimSuffix(ioc.Value, ".")), true
+	case DataTypeIPv4, DataTypeIPv6:
+		return "Intel::ADDR", ioc.Value, true
+	case DataTypeURLVerbatim:
+		// Zeek matches URLs without the scheme
+		url := ioc.Value
+		if i := strings.Index(url, "://"); i >= 0 {
+			url = url[i+3:]
+		}
+		return "Intel::URL", url, true
+	case DataTypeEmail:
+		return "Intel::EMAIL", ioc.Value, true
+	case DataTypeFileName:
+		return "Intel::FILE_NAME", ioc.Value, true
+	case DataTypeExactHash:
+		if algorithm, digest := HashAlgorithm(ioc.Value); algorithm != "" {
+			return "Intel::FILE_HASH", digest, true
+		}
+	}
+
+	return "", "", false
+}
+
+// zeekField escapes s for a field of a Zeek ASCII file, using "-" for empty
+// fields
+func zeekField(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c < 0x20 || c == 0x7f || c == '\\' {
+			fmt.Fprintf(&b, "\\x%02x", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ZeekIntelWriter writes IOCs as a Zeek Intelligence Framework input file.
+// The source pseudonyms of an IOC are its meta.source, the TIE ID and the
+// categories its meta.desc.
+type ZeekIntelWriter struct {
+	// Source is used as meta.source of IOCs without source pseudonyms
+	Source string
+	// Skipped counts the IOCs which could not be converted
+	Skipped int
+	// Logger, if set, is told about each skipped IOC
+	Logger *log.Logger
+
+	w       io.Writer
+	started bool
+}
+
+func NewZeekIntelWriter(w io.Writer) *ZeekIntelWriter {
+	return &ZeekIntelWriter{
+		Source: "DCSO TIE",
+		w:      w,
+	}
+}
+
+// SetLogger implements LoggerSetter
+func (zw *ZeekIntelWriter) SetLogger(l *log.Logger) {
+	zw.Logger = l
+}
+
+func (zw *ZeekIntelWriter) begin() error {
+	zw.started = true
+	_, err := fmt.Fprintf(zw.w, "#fields\t%s\n", strings.Join(zeekFields, "\t"))
+	return err
+}
+
+func (zw *ZeekIntelWriter) WriteIOC(ioc *IOC) error {
+	indicatorType, indicator, ok := ZeekIndicatorType(ioc)
+	if !ok {
+		logSkipped(zw.Logger, "Zeek", ioc)
+		zw.Skipped++
+		return nil
+	}
+
+	if !zw.started {
+		if err := zw.begin(); err != nil {
+			return err
+		}
+	}
+
+	source := zw.Source
+	if len(ioc.SourcePseudonyms) > 0 {
+		source = strings.Join(ioc.SourcePseudonyms, ",")
+	}
+	desc := strings.TrimSpace("DCSO TIE " + ioc.ID)
+	if len(ioc.Categories) > 0 {
+		desc += " (" + strings.Join(ioc.Categories, ",") + ")"
+	}
+
+	_, err := fmt.Fprintf(zw.w, "%s\t%s\t%s\t%s\n",
+		zeekField(indicator), indicatorType, zeekField(source), zeekField(desc))
+	return err
+}
+
+func (zw *ZeekIntelWriter) Close() error {
+	if !zw.started {
+		return zw.begin()
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package gotie
+
+// DCSO gotie API bindings
+// Copyright (c) 2018, DCSO GmbH
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestZeekIntelWriter(t *testing.T) {
+	iocs := []IOC{
+		{ID: "ioc-1", DataType: "DomainName", Value: "Evil.Example.com.", Categories: []string{"c2server", "botnet"},
+			SourcePseudonyms: []string{"src-a", "src-b"}},
+		{ID: "ioc-2", DataType: "URLVerbatim", Value: "http://bad.example.org/a\tb"},
+		{ID: "ioc-3", DataType: "ExactHash", Value: "SHA256:" + strings.Repeat("A", 64)},
+		{ID: "ioc-4", DataType: "Mutex", Value: "x"},
+	}
+
+	var out bytes.Buffer
+	zw := NewZeekIntelWriter(&out)
+	for i := range iocs {
+		if err := zw.WriteIOC(&iocs[i]); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if zw.Skipped != 1 {
+		t.Errorf("expected 1 skipped IOC, got %v", zw.Skipped)
+	}
+
+	expected := "#fields\tindicator\tindicator_type\tmeta.source\tmeta.desc\n" +
+		"evil.example.com\tIntel::DOMAIN\tsrc-a,src-b\tDCSO TIE ioc-1 (c2server,botnet)\n" +
+		"bad.example.org/a\\x09b\tIntel::URL\tDCSO TIE\tDCSO TIE ioc-2\n" +
+		strings.Repeat("a", 64) + "\tIntel::FILE_HASH\tDCSO TIE\tDCSO TIE ioc-3\n"
+	if out.String() != expected {
+		t.Errorf("unexpected intel file:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+
+	out.Reset()
+	zw = NewZeekIntelWriter(&out)
+	if err := zw.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !strings.HasPrefix(out.String(), "#fields\t") {
+		t.Errorf("expected header for empty intel file, got %q", out.String())
+	}
+}